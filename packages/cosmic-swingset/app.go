@@ -1,7 +1,9 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 
 	abci "github.com/tendermint/tendermint/abci/types"
@@ -16,6 +18,7 @@ import (
 	"github.com/cosmos/cosmos-sdk/types/module"
 	"github.com/cosmos/cosmos-sdk/x/auth"
 	"github.com/cosmos/cosmos-sdk/x/bank"
+	"github.com/cosmos/cosmos-sdk/x/crisis"
 	distr "github.com/cosmos/cosmos-sdk/x/distribution"
 	"github.com/cosmos/cosmos-sdk/x/genaccounts"
 	"github.com/cosmos/cosmos-sdk/x/genutil"
@@ -47,6 +50,7 @@ var (
 		params.AppModuleBasic{},
 		slashing.AppModuleBasic{},
 		supply.AppModuleBasic{},
+		crisisModuleBasic{},
 
 		swingset.AppModule{},
 	)
@@ -84,15 +88,22 @@ type swingSetApp struct {
 	distrKeeper    distr.Keeper
 	supplyKeeper   supply.Keeper
 	paramsKeeper   params.Keeper
+	crisisKeeper   crisis.Keeper
 	ssKeeper       swingset.Keeper
 
+	// Where the crisis keeper keeps its parameters
+	crisisSubspace params.Subspace
+
+	// How the swingset module reaches the kernel
+	bridge *swingset.Bridge
+
 	// Module Manager
 	mm *module.Manager
 }
 
-// NewSwingSetApp is a constructor function for swingSetApp
+// NewSwingSetApp is a constructor function for swingSetApp.
 func NewSwingSetApp(
-	logger log.Logger, db dbm.DB, baseAppOptions ...func(*bam.BaseApp),
+	bridge *swingset.Bridge, logger log.Logger, db dbm.DB, baseAppOptions ...func(*bam.BaseApp),
 ) *swingSetApp {
 
 	// First define the top level codec that will be shared by the different modules
@@ -112,6 +123,8 @@ func NewSwingSetApp(
 
 		keys:  keys,
 		tkeys: tkeys,
+
+		bridge: bridge,
 	}
 
 	// The ParamsKeeper handles parameter storage for the application
@@ -122,6 +135,8 @@ func NewSwingSetApp(
 	stakingSubspace := app.paramsKeeper.Subspace(staking.DefaultParamspace)
 	distrSubspace := app.paramsKeeper.Subspace(distr.DefaultParamspace)
 	slashingSubspace := app.paramsKeeper.Subspace(slashing.DefaultParamspace)
+	app.crisisSubspace = app.paramsKeeper.Subspace(crisis.DefaultParamspace)
+	swingsetSubspace := app.paramsKeeper.Subspace(swingset.DefaultParamspace)
 
 	// The AccountKeeper handles address -> account lookups
 	app.accountKeeper = auth.NewAccountKeeper(
@@ -185,20 +200,33 @@ func NewSwingSetApp(
 			app.slashingKeeper.Hooks()),
 	)
 
+	// The crisis keeper checks the invariants at genesis.  Scanning whole
+	// stores is too costly to do in blocks, so after that they are only
+	// checked offline, by `ag-chain-cosmos store check`.
+	app.crisisKeeper = crisis.NewKeeper(
+		app.crisisSubspace,
+		0,
+		app.supplyKeeper,
+		auth.FeeCollectorName,
+	)
+
 	// The SwingSetKeeper is the Keeper from the module for this tutorial
 	// It handles interactions with the kvstore
 	app.ssKeeper = swingset.NewKeeper(
 		app.bankKeeper,
 		keys[swingset.StoreKey],
+		swingsetSubspace,
 		app.cdc,
+		swingset.DefaultCodespace,
 	)
 
 	app.mm = module.NewManager(
 		genaccounts.NewAppModule(app.accountKeeper),
-		genutil.NewAppModule(app.accountKeeper, app.stakingKeeper, app.BaseApp.DeliverTx),
+		genutil.NewAppModule(app.accountKeeper, app.stakingKeeper, app.DeliverTx),
 		auth.NewAppModule(app.accountKeeper),
 		bank.NewAppModule(app.bankKeeper, app.accountKeeper),
-		swingset.NewAppModule(app.ssKeeper, app.bankKeeper),
+		crisisModule{crisis.NewAppModule(&app.crisisKeeper)},
+		swingset.NewAppModule(app.ssKeeper, app.bankKeeper, bridge),
 		supply.NewAppModule(app.supplyKeeper, app.accountKeeper),
 		distr.NewAppModule(app.distrKeeper, app.supplyKeeper),
 		slashing.NewAppModule(app.slashingKeeper, app.stakingKeeper),
@@ -206,7 +234,7 @@ func NewSwingSetApp(
 	)
 
 	app.mm.SetOrderBeginBlockers(distr.ModuleName, slashing.ModuleName,  swingset.ModuleName)
-	app.mm.SetOrderEndBlockers(staking.ModuleName)
+	app.mm.SetOrderEndBlockers(staking.ModuleName, swingset.ModuleName)
 
 	// Sets the order of Genesis - Order matters, genutil is to always come last
 	// NOTE: The genutil module must occur after staking so that pools are
@@ -220,9 +248,12 @@ func NewSwingSetApp(
 		slashing.ModuleName,
 		swingset.ModuleName,
 		supply.ModuleName,
+		crisis.ModuleName,
 		genutil.ModuleName,
 	)
 
+	app.mm.RegisterInvariants(&app.crisisKeeper)
+
 	// register all module routes and module queriers
 	app.mm.RegisterRoutes(app.Router(), app.QueryRouter())
 
@@ -268,6 +299,7 @@ func (app *swingSetApp) InitChainer(ctx sdk.Context, req abci.RequestInitChain)
 	}
 
 	res := app.mm.InitGenesis(ctx, genesisState)
+	app.setMissingCrisisParams(ctx)
 	if len(res.Validators) == 0 {
 		res.Validators = req.Validators
 	}
@@ -277,13 +309,81 @@ func (app *swingSetApp) InitChainer(ctx sdk.Context, req abci.RequestInitChain)
 func (app *swingSetApp) BeginBlocker(ctx sdk.Context, req abci.RequestBeginBlock) abci.ResponseBeginBlock {
 	return app.mm.BeginBlock(ctx, req)
 }
+
+// setMissingCrisisParams gives the crisis module its default constant fee, in
+// the bond denom, if it has none.  Genesis files and chains from before the
+// crisis module was added don't set one, so it is set at genesis, and made up
+// for when exporting a chain without it.
+func (app *swingSetApp) setMissingCrisisParams(ctx sdk.Context) {
+	if app.crisisSubspace.Has(ctx, crisis.ParamStoreKeyConstantFee) {
+		return
+	}
+	fee := crisis.DefaultGenesisState().ConstantFee
+	fee.Denom = app.stakingKeeper.BondDenom(ctx)
+	app.crisisKeeper.SetConstantFee(ctx, fee)
+}
+
+// crisisModuleBasic is the crisis module's AppModuleBasic, except that it
+// accepts a genesis without a crisis section, as setMissingCrisisParams
+// makes up for it.
+type crisisModuleBasic struct {
+	crisis.AppModuleBasic
+}
+
+func (b crisisModuleBasic) ValidateGenesis(bz json.RawMessage) error {
+	if len(bz) == 0 {
+		return nil
+	}
+	return b.AppModuleBasic.ValidateGenesis(bz)
+}
+
+// crisisModule is the crisis module without MsgVerifyInvariant, which would
+// let anyone make every validator scan whole stores within a tx.
+type crisisModule struct {
+	crisis.AppModule
+}
+
+// Route is empty, so that no handler is registered.
+func (crisisModule) Route() string { return "" }
+// DeliverTx keeps what the kernel did for a tx exactly when the tx's Cosmos
+// writes are kept, by ending the savepoints its deliveries left open.  If the
+// kernel can't be kept in step, the node halts rather than diverge from the
+// other validators.
+func (app *swingSetApp) DeliverTx(req abci.RequestDeliverTx) abci.ResponseDeliverTx {
+	res := app.BaseApp.DeliverTx(req)
+	if err := app.bridge.EndSavepoints(context.Background(), res.IsOK()); err != nil {
+		panic(fmt.Sprintf("swingset: cannot end savepoints after tx: %s", err))
+	}
+	if err := app.bridge.Halted(); err != nil {
+		panic(fmt.Sprintf("swingset: controller halted: %s", err))
+	}
+	return res
+}
+
+// Query undoes whatever the kernel did for a simulated tx, since the
+// simulation's Cosmos writes are always thrown away.  Queries can't halt the
+// node, so a failure halts the bridge, and the next block halts on it.
+func (app *swingSetApp) Query(req abci.RequestQuery) abci.ResponseQuery {
+	res := app.BaseApp.Query(req)
+	if err := app.bridge.EndSavepoints(context.Background(), false); err != nil {
+		app.bridge.Halt(fmt.Errorf("cannot abort simulation savepoints: %s", err))
+	}
+	return res
+}
+
 func (app *swingSetApp) EndBlocker(ctx sdk.Context, req abci.RequestEndBlock) abci.ResponseEndBlock {
 	return app.mm.EndBlock(ctx, req)
 }
+
 func (app *swingSetApp) LoadHeight(height int64) error {
 	return app.LoadVersion(height, app.keys[bam.MainStoreKey])
 }
 
+// SwingSetKeeper gives offline tools access to the swingset store.
+func (app *swingSetApp) SwingSetKeeper() swingset.Keeper {
+	return app.ssKeeper
+}
+
 // ModuleAccountAddrs returns all the app's module account addresses.
 func (app *swingSetApp) ModuleAccountAddrs() map[string]bool {
 	modAccAddrs := make(map[string]bool)
@@ -302,6 +402,8 @@ func (app *swingSetApp) ExportAppStateAndValidators(forZeroHeight bool, jailWhit
 	// as if they could withdraw from the start of the next block
 	ctx := app.NewContext(true, abci.Header{Height: app.LastBlockHeight()})
 
+	// Only the exported copy gets it; the state is left as it was.
+	app.setMissingCrisisParams(ctx)
 	genState := app.mm.ExportGenesis(ctx)
 	appState, err = codec.MarshalJSONIndent(app.cdc, genState)
 	if err != nil {
@@ -4,8 +4,10 @@ go 1.12
 
 require (
 	github.com/cosmos/cosmos-sdk v0.37.6
+	github.com/go-kit/kit v0.9.0
 	github.com/gorilla/mux v1.7.0
 	github.com/mattn/go-isatty v0.0.7 // indirect
+	github.com/prometheus/client_golang v0.9.3
 	github.com/spf13/afero v1.2.2 // indirect
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.6.1
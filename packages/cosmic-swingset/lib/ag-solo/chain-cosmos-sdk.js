@@ -229,8 +229,12 @@ export async function connectToChain(
         console.error(stderr);
         console.log(` helper said: ${stdout}`);
         try {
-          // Try to parse the stdout.
-          return JSON.parse(JSON.parse(JSON.parse(stdout).value));
+          // Try to parse the stdout, whose numbers are strings.
+          const { outbox, ack } = JSON.parse(stdout);
+          return {
+            outbox: (outbox || []).map(({ num, body }) => [Number(num), body]),
+            ack: Number(ack),
+          };
         } catch (e) {
           console.log(` failed to parse output:`, e);
         }
@@ -70,13 +70,14 @@ private:
 Napi::FunctionReference NodeReplier::constructor;
 
 static int daemonPort = -1;
-int SendToNode(int port, int replyPort, Body str) {
+// Go owns str, so copy it before returning.
+int SendToNode(int port, int replyPort, Body str, size_t len) {
     //std::cerr << "Send to node port " << port << " " << str << std::endl;
     // FIXME: Make a better bootstrap, honouring an AG_COSMOS_START message.
     if (daemonPort < 0) {
         daemonPort = replyPort;
     }
-    std::string instr(str);
+    std::string instr(str, len);
     std::thread([instr, port, replyPort]{
         auto promise = std::make_shared<std::promise<NodeReply>>();
         dispatcher->call(
@@ -94,10 +95,12 @@ int SendToNode(int port, int replyPort, Body str) {
             try {
                 NodeReply ret = promise->get_future().get();
                 // std::cerr << "Replying to Go with " << ret.value() << " " << ret.isRejection() << std::endl;
-                ReplyToGo(replyPort, ret.isRejection(), ret.value().c_str());
+                std::string value = ret.value();
+                ReplyToGo(replyPort, ret.isRejection(), value.data(), value.size());
             } catch (std::exception& e) {
                 // std::cerr << "Exceptioning " << e.what() << std::endl;
-                ReplyToGo(replyPort, true, e.what());
+                std::string what(e.what());
+                ReplyToGo(replyPort, true, what.data(), what.size());
             }
         }
         // std::cerr << "Thread is finished" << std::endl;
@@ -111,8 +114,12 @@ static Napi::Value send(const Napi::CallbackInfo& info) {
     Napi::Env env = info.Env();
     int instance = info[0].As<Napi::Number>();
     std::string tmp = info[1].As<Napi::String>().Utf8Value();
-    Body ret = SendToGo(instance, tmp.c_str());
-    return Napi::String::New(env, ret);
+    size_t retLen = 0;
+    char* ret = SendToGo(instance, tmp.data(), tmp.size(), &retLen);
+    // Go allocated ret for us, so we must free it.
+    Napi::String str = Napi::String::New(env, ret, retLen);
+    free(ret);
+    return str;
 }
 
 static Napi::Value runAG_COSMOS(const Napi::CallbackInfo& info) {
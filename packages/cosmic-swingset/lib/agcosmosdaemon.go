@@ -2,58 +2,44 @@ package main
 
 // /* These comments before the import "C" are included in the C output. */
 // #include <stdlib.h>
+// /*
+//  * Message bodies cross the bridge as a pointer and a length, so they may
+//  * hold any bytes.  The side that allocates a body frees it: a callee copies
+//  * what it needs before returning.  The only exception is the result of
+//  * SendToGo, which Go allocates with malloc and the caller must free().
+//  */
 // typedef const char* Body;
-// typedef int (*sendFunc)(int, int, Body);
-// inline int invokeSendFunc(sendFunc send, int port, int reply, Body str) {
-//    return send(port, reply, str);
+// typedef int (*sendFunc)(int, int, Body, size_t);
+// inline int invokeSendFunc(sendFunc send, int port, int reply, Body str, size_t len) {
+//    return send(port, reply, str, len);
 // }
 import "C"
 
 import (
-	"errors"
 	"fmt"
+	"math"
 	"os"
 
 	"github.com/Agoric/cosmic-swingset/lib/daemon"
 	swingset "github.com/Agoric/cosmic-swingset/x/swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
 )
 
-type goReturn = struct {
-	str string
-	err error
-}
-
 const SwingSetPort = 123
 
-var replies = map[int]chan goReturn{}
-var lastReply = 0
+// The one bridge to Node, reached from the exported callbacks below.
+var bridge *swingset.Bridge
 
 //export RunAG_COSMOS
 func RunAG_COSMOS(nodePort C.int, toNode C.sendFunc, cosmosArgs []*C.char) C.int {
-	// FIXME: Decouple the sending logic from the Cosmos app.
-	sendToNode := func(needReply bool, str string) (string, error) {
-		var rPort int
-		if needReply {
-			lastReply++
-			rPort = lastReply
-			replies[rPort] = make(chan goReturn)
-		}
-
-		// Send the message
-		C.invokeSendFunc(toNode, nodePort, C.int(rPort), C.CString(str))
-		if !needReply {
-			// Return immediately
-			// fmt.Fprintln(os.Stderr, "Don't wait")
-			return "<no-reply-requested>", nil
-		}
-
-		// Block the sending goroutine while we wait for the reply
-		// fmt.Fprintln(os.Stderr, "Waiting for", rPort)
-		ret := <-replies[rPort]
-		delete(replies, rPort)
-		// fmt.Fprintln(os.Stderr, "Woken, got", ret)
-		return ret.str, ret.err
+	sendToNode := func(replyPort int, str string) error {
+		// Send the message, which Node copies before returning.
+		body := C.CBytes([]byte(str))
+		defer C.free(body)
+		C.invokeSendFunc(toNode, nodePort, C.int(replyPort), C.Body(body), C.size_t(len(str)))
+		return nil
 	}
+	bridge = swingset.NewBridge(sendToNode)
 
 	args := make([]string, len(cosmosArgs))
 	for i, s := range cosmosArgs {
@@ -64,7 +50,7 @@ func RunAG_COSMOS(nodePort C.int, toNode C.sendFunc, cosmosArgs []*C.char) C.int
 	go func() {
 		// We run in the background, but exit when the job is over.
 		// swingset.SendToNode("hello from Initial Go!")
-		daemon.RunWithController(sendToNode)
+		daemon.RunWithController(bridge)
 		// fmt.Fprintln(os.Stderr, "Shutting down Cosmos")
 		os.Exit(0)
 	}()
@@ -72,38 +58,47 @@ func RunAG_COSMOS(nodePort C.int, toNode C.sendFunc, cosmosArgs []*C.char) C.int
 	return SwingSetPort
 }
 
+// goBody copies a message body from Node.  C.GoStringN takes an int length,
+// so a body it can't take is refused rather than truncated.
+func goBody(str C.Body, strLen C.size_t) (string, error) {
+	if strLen > math.MaxInt32 {
+		return "", fmt.Errorf("message body of %d bytes is too large", uint64(strLen))
+	}
+	return C.GoStringN(str, C.int(strLen)), nil
+}
+
 //export ReplyToGo
-func ReplyToGo(replyPort C.int, isError C.int, str C.Body) C.int {
-	goStr := C.GoString(str)
+func ReplyToGo(replyPort C.int, isError C.int, str C.Body, strLen C.size_t) C.int {
+	goStr, err := goBody(str, strLen)
 	// fmt.Fprintln(os.Stderr, "Reply to Go", goStr)
-	returnCh := replies[int(replyPort)]
-	if returnCh == nil {
-		// Unexpected reply.
-		// This is okay, since the caller decides whether or
-		// not she wants to listen for replies.
-		return C.int(0)
-	}
 	// Wake up the waiting goroutine
-	ret := goReturn{}
-	if int(isError) == 0 {
-		ret.str = goStr
+	if err != nil {
+		bridge.Reply(int(replyPort), "", err)
+	} else if int(isError) == 0 {
+		bridge.Reply(int(replyPort), goStr, nil)
 	} else {
-		ret.err = errors.New(goStr)
+		bridge.Reply(int(replyPort), "", protocol.ParseKernelError(goStr))
 	}
-	returnCh <- ret
 	return C.int(0)
 }
 
+// SendToGo answers a message from Node.  The answer is allocated with malloc,
+// its length is stored in *outLen, and the caller must free() it.
+//
 //export SendToGo
-func SendToGo(port C.int, str C.Body) C.Body {
-	goStr := C.GoString(str)
+func SendToGo(port C.int, str C.Body, strLen C.size_t, outLen *C.size_t) *C.char {
+	goStr, err := goBody(str, strLen)
 	// fmt.Fprintln(os.Stderr, "Send to Go", goStr)
-	outstr, err := swingset.ReceiveFromNode(int(port), goStr)
+	outstr := ""
+	if err == nil {
+		outstr, err = bridge.ReceiveFromNode(int(port), goStr)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Cannot receive from node", err)
-		return C.CString("")
+		outstr = ""
 	}
-	return C.CString(outstr)
+	*outLen = C.size_t(len(outstr))
+	return (*C.char)(C.CBytes([]byte(outstr)))
 }
 
 // Do nothing in main.
@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+func setPolicy(ctx sdk.Context, k swingset.Keeper, policy string, maxRetries uint16) {
+	params := k.GetParams(ctx)
+	params.BeginBlockFailurePolicy = policy
+	params.MaxRetries = maxRetries
+	params.RetryBackoff = 0
+	k.SetParams(ctx, params)
+}
+
+// beginBlock runs BeginBlock, and returns what it panicked with if it halted.
+func beginBlock(ctx sdk.Context, k swingset.Keeper, fc *fakeController) (halted interface{}) {
+	defer func() {
+		halted = recover()
+	}()
+	swingset.BeginBlock(ctx, k, fc.bridge)
+	return nil
+}
+
+// checkActions fails the test unless both the fake and storage have counted
+// want actions.
+func checkActions(t *testing.T, ctx sdk.Context, k swingset.Keeper, fc *fakeController, want int) {
+	t.Helper()
+	if fc.state.actions != want {
+		t.Errorf("kernel has done %d actions, want %d", fc.state.actions, want)
+	}
+	stored := k.GetStorage(ctx, fakeActionsKey).Value
+	if want == 0 && stored == "" {
+		return
+	}
+	if stored != strconv.Itoa(want) {
+		t.Errorf("storage has counted %q actions, want %d", stored, want)
+	}
+	if len(fc.savepoints) != 0 {
+		t.Errorf("%d savepoints left open", len(fc.savepoints))
+	}
+}
+
+func TestBeginBlockHaltPolicy(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+	setPolicy(ctx, k, swingset.PolicyHalt, 3)
+
+	fc.failures[protocol.TypeBeginBlock] = 1
+	if beginBlock(ctx, k, fc) == nil {
+		t.Fatal("BeginBlock didn't halt on a rejection")
+	}
+	checkActions(t, ctx, k, fc, 0)
+}
+
+func TestBeginBlockRetryPolicy(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+	setPolicy(ctx, k, swingset.PolicyRetry, 2)
+
+	fc.failures[protocol.TypeBeginBlock] = 2
+	if halted := beginBlock(ctx, k, fc); halted != nil {
+		t.Fatalf("BeginBlock halted though a retry succeeded: %v", halted)
+	}
+	// The failed attempts left nothing behind in either.
+	checkActions(t, ctx, k, fc, 1)
+
+	fc.failures[protocol.TypeBeginBlock] = 3
+	if beginBlock(ctx, k, fc) == nil {
+		t.Fatal("BeginBlock didn't halt when every retry failed")
+	}
+	checkActions(t, ctx, k, fc, 1)
+}
+
+func TestBeginBlockDegradePolicy(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+	setPolicy(ctx, k, swingset.PolicyDegrade, 3)
+
+	fc.failures[protocol.TypeBeginBlock] = 1
+	if halted := beginBlock(ctx, k, fc); halted != nil {
+		t.Fatalf("BeginBlock halted with the degrade policy: %v", halted)
+	}
+	checkActions(t, ctx, k, fc, 0)
+	if !k.GetKernelStatus(ctx).Degraded {
+		t.Fatal("kernel isn't degraded")
+	}
+	res := deliverTx(t, ctx, k, fc, newDelivery("peer", 1), true)
+	if want := swingset.ErrKernelDegraded(k.Codespace(), 1).Code(); res.Code != want {
+		t.Errorf("delivery to a degraded kernel = code %d, want %d", res.Code, want)
+	}
+
+	if halted := beginBlock(ctx, k, fc); halted != nil {
+		t.Fatalf("BeginBlock halted: %v", halted)
+	}
+	if k.GetKernelStatus(ctx).Degraded {
+		t.Error("kernel is still degraded after BEGIN_BLOCK succeeded")
+	}
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 1), true); !res.IsOK() {
+		t.Errorf("delivery after recovering: %s", res.Log)
+	}
+	checkActions(t, ctx, k, fc, 2)
+}
+
+func TestBeginBlockHaltsWhenControllerFails(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+	setPolicy(ctx, k, swingset.PolicyDegrade, 3)
+
+	fc.bridge.Halt(errors.New("controller went away"))
+	if beginBlock(ctx, k, fc) == nil {
+		t.Fatal("BeginBlock didn't halt when the controller failed")
+	}
+	if k.GetKernelStatus(ctx).Degraded {
+		t.Error("a failure of this node's controller degraded the kernel")
+	}
+}
+
+func TestBeginBlockOutOfGas(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+	setPolicy(ctx, k, swingset.PolicyDegrade, 3)
+	params := k.GetParams(ctx)
+	params.BeginBlockGasLimit = 100
+	k.SetParams(ctx, params)
+
+	// Every validator runs out alike, so the policy applies.
+	if halted := beginBlock(ctx, k, fc); halted != nil {
+		t.Fatalf("BeginBlock halted on running out of gas: %v", halted)
+	}
+	if !k.GetKernelStatus(ctx).Degraded {
+		t.Error("kernel isn't degraded after running out of gas")
+	}
+	checkActions(t, ctx, k, fc, 0)
+}
@@ -0,0 +1,374 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// FlagController selects the SwingSet controller for `ag-chain-cosmos start`.
+const FlagController = "controller"
+
+// An out-of-process controller speaks length-prefixed JSON frames: a 4-byte
+// big-endian length followed by a controllerFrame.  Both sides may send.
+//
+// Go sends actions to port 0, the controller's action port.  The controller
+// sends storage requests to whatever storagePort the action named.  A frame
+// with a nonzero ReplyPort must be answered by a "reply" frame carrying the
+// same ReplyPort.
+type controllerFrame struct {
+	Type      string `json:"type"`
+	Port      int    `json:"port,omitempty"`
+	ReplyPort int    `json:"replyPort,omitempty"`
+	IsError   bool   `json:"isError,omitempty"`
+	Body      string `json:"body"`
+}
+
+const (
+	frameSend  = "send"
+	frameReply = "reply"
+
+	maxFrameSize = 1 << 30
+	// One-way messages held while the controller is away, beyond which
+	// they're refused.
+	maxUnsent = 1000
+
+	minRedialDelay = 100 * time.Millisecond
+	maxRedialDelay = 5 * time.Second
+)
+
+type controllerConn struct {
+	dial   func() (io.ReadWriteCloser, error)
+	bridge *swingset.Bridge
+	logger log.Logger
+
+	mu         sync.Mutex
+	conn       io.ReadWriteCloser
+	ready      bool
+	generation int
+	// unanswered holds the calls sent to the controller that it has not
+	// replied to yet, so that they can be resent to a new controller.
+	unanswered map[int]controllerFrame
+	unsent     []controllerFrame
+	// resyncs are our own calls made while resynchronizing, which use
+	// negative reply ports so as not to collide with the bridge's.
+	lastResync int
+	resyncs    map[int]chan controllerReply
+}
+
+type controllerReply struct {
+	str string
+	err error
+}
+
+// NewControllerBridge returns a Bridge that talks to an external controller
+// process.  The spec is either "unix:PATH", to dial a Unix socket, or
+// "exec:COMMAND", to spawn COMMAND and use its stdin and stdout.  The
+// connection is reestablished whenever it drops, after which the controller
+// is reinitialized and any unanswered calls are resent.
+func NewControllerBridge(spec string, logger log.Logger) (*swingset.Bridge, error) {
+	var dial func() (io.ReadWriteCloser, error)
+	switch {
+	case strings.HasPrefix(spec, "unix:"):
+		path := strings.TrimPrefix(spec, "unix:")
+		dial = func() (io.ReadWriteCloser, error) {
+			return net.Dial("unix", path)
+		}
+	case strings.HasPrefix(spec, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(args) == 0 {
+			return nil, errors.New("controller exec: needs a command")
+		}
+		dial = func() (io.ReadWriteCloser, error) {
+			return spawnController(args)
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized controller %q", spec)
+	}
+
+	return newControllerConn(dial, logger).bridge, nil
+}
+
+// newControllerConn starts talking to whatever controller dial connects to.
+func newControllerConn(dial func() (io.ReadWriteCloser, error), logger log.Logger) *controllerConn {
+	cc := &controllerConn{
+		dial:       dial,
+		logger:     logger.With("module", "controller"),
+		unanswered: map[int]controllerFrame{},
+		resyncs:    map[int]chan controllerReply{},
+	}
+	cc.bridge = swingset.NewBridge(cc.send)
+	cc.bridge.SetAbandonHandler(cc.abandon)
+	go cc.run()
+	return cc
+}
+
+func (cc *controllerConn) send(replyPort int, str string) error {
+	frame := controllerFrame{Type: frameSend, ReplyPort: replyPort, Body: str}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if replyPort != 0 {
+		cc.unanswered[replyPort] = frame
+	}
+	if cc.ready {
+		cc.writeLocked(frame)
+	} else if replyPort == 0 {
+		// Unanswered calls are resent on their own once we resync.
+		if len(cc.unsent) >= maxUnsent {
+			return fmt.Errorf("controller unavailable with %d messages already waiting", len(cc.unsent))
+		}
+		cc.unsent = append(cc.unsent, frame)
+	}
+	return nil
+}
+
+// abandon forgets a call the bridge gave up on, so that it isn't resent.
+func (cc *controllerConn) abandon(replyPort int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.unanswered, replyPort)
+}
+
+// writeLocked writes a frame to the current connection.  On failure, the
+// connection is torn down, and the reader notices and redials.
+func (cc *controllerConn) writeLocked(frame controllerFrame) {
+	if cc.conn == nil {
+		return
+	}
+	if err := writeFrame(cc.conn, frame); err != nil {
+		cc.logger.Error("cannot write to controller", "err", err)
+		cc.ready = false
+		cc.conn.Close()
+	}
+}
+
+func (cc *controllerConn) run() {
+	delay := minRedialDelay
+	for {
+		conn, err := cc.dial()
+		if err != nil {
+			cc.logger.Error("cannot connect to controller", "err", err, "retry", delay)
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxRedialDelay {
+				delay = maxRedialDelay
+			}
+			continue
+		}
+		delay = minRedialDelay
+
+		cc.mu.Lock()
+		cc.conn = conn
+		cc.generation++
+		generation := cc.generation
+		cc.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			cc.readLoop(conn)
+			close(done)
+		}()
+
+		if err := cc.resync(generation, done); err != nil {
+			cc.logger.Error("cannot resync controller", "err", err)
+			conn.Close()
+		}
+
+		<-done
+		cc.mu.Lock()
+		cc.conn = nil
+		cc.ready = false
+		cc.mu.Unlock()
+		conn.Close()
+		cc.logger.Info("controller disconnected")
+	}
+}
+
+// resync brings a fresh controller up to date.  A reconnected controller has
+// lost its state, so it is initialized again before we resend whatever it
+// never answered.
+func (cc *controllerConn) resync(generation int, done <-chan struct{}) error {
+	if generation > 1 {
+		init, err := json.Marshal(protocol.NewInit())
+		if err != nil {
+			return err
+		}
+		cc.mu.Lock()
+		cc.lastResync--
+		replyPort := cc.lastResync
+		ch := make(chan controllerReply, 1)
+		cc.resyncs[replyPort] = ch
+		cc.writeLocked(controllerFrame{Type: frameSend, ReplyPort: replyPort, Body: string(init)})
+		cc.mu.Unlock()
+
+		defer func() {
+			cc.mu.Lock()
+			delete(cc.resyncs, replyPort)
+			cc.mu.Unlock()
+		}()
+
+		select {
+		case ret := <-ch:
+			if ret.err != nil {
+				return ret.err
+			}
+			// The controller must still speak our protocol.
+			if _, err := protocol.ParseInitReply(ret.str); err != nil {
+				return err
+			}
+		case <-done:
+			return errors.New("connection lost during resync")
+		}
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.conn == nil || cc.generation != generation {
+		return errors.New("connection lost during resync")
+	}
+	replyPorts := make([]int, 0, len(cc.unanswered))
+	for replyPort := range cc.unanswered {
+		replyPorts = append(replyPorts, replyPort)
+	}
+	sort.Ints(replyPorts)
+	cc.ready = true
+	for _, replyPort := range replyPorts {
+		cc.writeLocked(cc.unanswered[replyPort])
+	}
+	for _, frame := range cc.unsent {
+		cc.writeLocked(frame)
+	}
+	cc.unsent = nil
+	cc.logger.Info("controller connected", "generation", generation, "resent", len(replyPorts))
+	return nil
+}
+
+func (cc *controllerConn) readLoop(conn io.ReadWriteCloser) {
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				cc.logger.Error("cannot read from controller", "err", err)
+			}
+			return
+		}
+
+		switch frame.Type {
+		case frameReply:
+			var err error
+			if frame.IsError {
+				err = protocol.ParseKernelError(frame.Body)
+			}
+			cc.mu.Lock()
+			delete(cc.unanswered, frame.ReplyPort)
+			resync := cc.resyncs[frame.ReplyPort]
+			cc.mu.Unlock()
+			if resync != nil {
+				resync <- controllerReply{str: frame.Body, err: err}
+				continue
+			}
+			cc.bridge.Reply(frame.ReplyPort, frame.Body, err)
+
+		case frameSend:
+			// Handle storage requests in order, since the controller is
+			// blocked waiting for each answer.
+			out, err := cc.bridge.ReceiveFromNode(frame.Port, frame.Body)
+			if frame.ReplyPort == 0 {
+				continue
+			}
+			reply := controllerFrame{Type: frameReply, ReplyPort: frame.ReplyPort, Body: out}
+			if err != nil {
+				reply.IsError = true
+				reply.Body = err.Error()
+			}
+			cc.mu.Lock()
+			if cc.conn == conn {
+				cc.writeLocked(reply)
+			}
+			cc.mu.Unlock()
+
+		default:
+			cc.logger.Error("unrecognized controller frame", "type", frame.Type)
+		}
+	}
+}
+
+func readFrame(r io.Reader) (controllerFrame, error) {
+	var frame controllerFrame
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return frame, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return frame, err
+	}
+	err := json.Unmarshal(buf, &frame)
+	return frame, err
+}
+
+func writeFrame(w io.Writer, frame controllerFrame) error {
+	bz, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(bz))
+	binary.BigEndian.PutUint32(buf, uint32(len(bz)))
+	copy(buf[4:], bz)
+	_, err = w.Write(buf)
+	return err
+}
+
+// execConn joins the pipes of a spawned controller into one connection.
+type execConn struct {
+	io.Reader
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (ec *execConn) Close() error {
+	err := ec.WriteCloser.Close()
+	if ec.cmd.Process != nil {
+		ec.cmd.Process.Kill()
+	}
+	return err
+}
+
+func spawnController(args []string) (io.ReadWriteCloser, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go cmd.Wait()
+	return &execConn{Reader: stdout, WriteCloser: stdin, cmd: cmd}, nil
+}
@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	frames := []controllerFrame{
+		{Type: frameSend, ReplyPort: 3, Body: `{"type":"BEGIN_BLOCK"}`},
+		{Type: frameReply, ReplyPort: 3, IsError: true, Body: "no"},
+		{Type: frameSend, Port: 7, Body: ""},
+	}
+	for _, frame := range frames {
+		if err := writeFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range frames {
+		got, err := readFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("read frame %+v, want %+v", got, want)
+		}
+	}
+	if _, err := readFrame(&buf); err != io.EOF {
+		t.Errorf("reading past the last frame = %v, want EOF", err)
+	}
+
+	// A truncated frame is an error, not the end of the stream.
+	if err := writeFrame(&buf, frames[0]); err != nil {
+		t.Fatal(err)
+	}
+	buf.Truncate(buf.Len() - 1)
+	if _, err := readFrame(&buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("reading a truncated frame = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], maxFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(header[:])); err == nil {
+		t.Error("read a frame over the size limit")
+	}
+}
+
+// testController is the controller's end of a connection.
+type testController struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// connectController hands cc a new connection, whose other end it returns.
+func connectController(t *testing.T, dials chan<- io.ReadWriteCloser) *testController {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	dials <- ours
+	return &testController{t: t, conn: theirs}
+}
+
+func (tc *testController) read() controllerFrame {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := readFrame(tc.conn)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return frame
+}
+
+func (tc *testController) reply(replyPort int, body string) {
+	tc.t.Helper()
+	if err := writeFrame(tc.conn, controllerFrame{Type: frameReply, ReplyPort: replyPort, Body: body}); err != nil {
+		tc.t.Fatal(err)
+	}
+}
+
+// answerInit answers the Init that a reconnected controller is sent first.
+func (tc *testController) answerInit() {
+	tc.t.Helper()
+	frame := tc.read()
+	if !strings.Contains(frame.Body, protocol.TypeInit) || frame.ReplyPort >= 0 {
+		tc.t.Fatalf("reconnected controller was first sent %+v, want an Init", frame)
+	}
+	bz, err := json.Marshal(protocol.InitReply{ProtocolVersion: protocol.Version, Capabilities: protocol.Capabilities})
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	tc.reply(frame.ReplyPort, string(bz))
+}
+
+func newTestControllerConn() (*controllerConn, chan io.ReadWriteCloser) {
+	dials := make(chan io.ReadWriteCloser)
+	cc := newControllerConn(func() (io.ReadWriteCloser, error) {
+		return <-dials, nil
+	}, log.NewNopLogger())
+	return cc, dials
+}
+
+type callResult struct {
+	ret string
+	err error
+}
+
+func call(ctx context.Context, cc *controllerConn, body string) <-chan callResult {
+	ch := make(chan callResult, 1)
+	go func() {
+		ret, err := cc.bridge.CallToNode(ctx, body)
+		ch <- callResult{ret, err}
+	}()
+	return ch
+}
+
+func TestControllerResendsAfterReconnect(t *testing.T) {
+	cc, dials := newTestControllerConn()
+
+	first := connectController(t, dials)
+	result := call(context.Background(), cc, `{"type":"BEGIN_BLOCK"}`)
+	sent := first.read()
+	first.conn.Close()
+
+	second := connectController(t, dials)
+	second.answerInit()
+	if resent := second.read(); resent != sent {
+		t.Fatalf("resent %+v, want %+v", resent, sent)
+	}
+	second.reply(sent.ReplyPort, "true")
+	if res := <-result; res.err != nil || res.ret != "true" {
+		t.Errorf("call after reconnecting = %q, %v", res.ret, res.err)
+	}
+}
+
+func TestControllerForgetsAbandonedCalls(t *testing.T) {
+	cc, dials := newTestControllerConn()
+
+	first := connectController(t, dials)
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := call(ctx, cc, `{"type":"BEGIN_BLOCK"}`)
+	first.read()
+	cancel()
+	if res := <-abandoned; res.err != context.Canceled {
+		t.Fatalf("cancelled call = %q, %v", res.ret, res.err)
+	}
+
+	result := call(context.Background(), cc, `{"type":"DELIVER_INBOUND"}`)
+	sent := first.read()
+	first.conn.Close()
+
+	// Only the call still waiting is resent.
+	second := connectController(t, dials)
+	second.answerInit()
+	if resent := second.read(); resent != sent {
+		t.Fatalf("resent %+v, want %+v", resent, sent)
+	}
+	second.reply(sent.ReplyPort, "true")
+	if res := <-result; res.err != nil {
+		t.Errorf("call after reconnecting: %s", res.err)
+	}
+}
+
+func TestControllerCapsUnsent(t *testing.T) {
+	// Never connected, so one-way messages wait.
+	cc, _ := newTestControllerConn()
+	for i := 0; i < maxUnsent; i++ {
+		if err := cc.bridge.SendToNode("{}"); err != nil {
+			t.Fatalf("message %d: %s", i, err)
+		}
+	}
+	if err := cc.bridge.SendToNode("{}"); err == nil {
+		t.Error("more messages than maxUnsent were held")
+	}
+}
@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// fakeController stands in for the SwingSet kernel.  It answers every action
+// deterministically, and keeps a toy mailbox per peer that echoes each
+// inbound message back out, so that chains can run without Node.  Like the
+// kernel, it has state of its own, which savepoints roll back; it counts its
+// actions, and fails any action that finds the count in storage different.
+type fakeController struct {
+	bridge     *swingset.Bridge
+	state      fakeState
+	savepoints []fakeSavepoint
+
+	// failures says how many more times to fail each action type, after
+	// doing its work, and down which action types can't reach the fake at
+	// all, as if the controller had gone away, for tests.
+	failures map[string]int
+	down     map[string]bool
+}
+
+type fakeState struct {
+	launched bool
+	actions  int
+}
+
+type fakeSavepoint struct {
+	id    int
+	state fakeState
+}
+
+// fakeActionsKey is where the fake keeps its count of actions in storage.
+const fakeActionsKey = "fake.actions"
+
+// NewFakeBridge returns a Bridge to an in-process fake controller.
+func NewFakeBridge() *swingset.Bridge {
+	return newFakeController().bridge
+}
+
+func newFakeController() *fakeController {
+	fc := &fakeController{failures: map[string]int{}, down: map[string]bool{}}
+	fc.bridge = swingset.NewBridge(fc.send)
+	return fc
+}
+
+func (fc *fakeController) send(replyPort int, str string) error {
+	if action, _ := protocol.ParseActionHeader(str); fc.down[action.Type] {
+		return fmt.Errorf("fake controller is down for %s", action.Type)
+	}
+	ret, err := fc.dispatch(str)
+	if replyPort != 0 {
+		if _, ok := err.(*protocol.KernelError); err != nil && !ok {
+			err = &protocol.KernelError{Code: protocol.ErrorCodeInternal, Message: err.Error()}
+		}
+		fc.bridge.Reply(replyPort, ret, err)
+	}
+	return nil
+}
+
+func (fc *fakeController) dispatch(str string) (string, error) {
+	actionType, err := protocol.ParseAction(str)
+	if err != nil {
+		return "", &protocol.KernelError{Code: protocol.ErrorCodeMalformed, Message: err.Error()}
+	}
+	switch actionType {
+	case protocol.TypeInit:
+		bz, err := json.Marshal(protocol.InitReply{
+			ProtocolVersion: protocol.Version,
+			Capabilities:    protocol.Capabilities,
+		})
+		return string(bz), err
+	case protocol.TypeBeginBlock:
+		var action protocol.BeginBlock
+		if err := json.Unmarshal([]byte(str), &action); err != nil {
+			return "", &protocol.KernelError{Code: protocol.ErrorCodeMalformed, Message: err.Error()}
+		}
+		return protocol.DoneReply, fc.injectFailure(actionType, fc.countAction(action.StoragePort))
+	case protocol.TypeDeliverInbound:
+		var action protocol.DeliverInbound
+		if err := json.Unmarshal([]byte(str), &action); err != nil {
+			return "", &protocol.KernelError{Code: protocol.ErrorCodeMalformed, Message: err.Error()}
+		}
+		err := fc.countAction(action.StoragePort)
+		if err == nil {
+			err = fc.deliverInbound(action)
+		}
+		return protocol.DoneReply, fc.injectFailure(actionType, err)
+	case protocol.TypeSavepoint, protocol.TypeCommitSavepoint, protocol.TypeAbortSavepoint:
+		var action protocol.Savepoint
+		if err := json.Unmarshal([]byte(str), &action); err != nil {
+			return "", &protocol.KernelError{Code: protocol.ErrorCodeMalformed, Message: err.Error()}
+		}
+		return protocol.DoneReply, fc.savepoint(action)
+	default:
+		return "", &protocol.KernelError{
+			Code:    protocol.ErrorCodeMalformed,
+			Message: fmt.Sprintf("Unknown action type %s", actionType),
+		}
+	}
+}
+
+func (fc *fakeController) injectFailure(actionType string, err error) error {
+	if err != nil || fc.failures[actionType] == 0 {
+		return err
+	}
+	fc.failures[actionType]--
+	return &protocol.KernelError{Code: protocol.ErrorCodeInternal, Message: "injected failure"}
+}
+
+func (fc *fakeController) savepoint(action protocol.Savepoint) error {
+	if action.Type == protocol.TypeSavepoint {
+		fc.savepoints = append(fc.savepoints, fakeSavepoint{id: action.Savepoint, state: fc.state})
+		return nil
+	}
+	n := len(fc.savepoints)
+	if n == 0 || fc.savepoints[n-1].id != action.Savepoint {
+		return fmt.Errorf("savepoint %d is not the innermost one open", action.Savepoint)
+	}
+	if action.Type == protocol.TypeAbortSavepoint {
+		fc.state = fc.savepoints[n-1].state
+	}
+	fc.savepoints = fc.savepoints[:n-1]
+	return nil
+}
+
+// countAction counts an action both in the fake's state and in storage.  On
+// its first action, the fake takes up the count in storage, as the kernel
+// loads its state when it's launched.
+func (fc *fakeController) countAction(port int) error {
+	ret, err := fc.storage(port, protocol.StorageRequest{Method: "get", Key: fakeActionsKey})
+	if err != nil {
+		return err
+	}
+	stored := 0
+	if ret != "null" {
+		var value string
+		if err := json.Unmarshal([]byte(ret), &value); err != nil {
+			return err
+		}
+		if stored, err = strconv.Atoi(value); err != nil {
+			return err
+		}
+	}
+	if !fc.state.launched {
+		fc.state = fakeState{launched: true, actions: stored}
+	} else if stored != fc.state.actions {
+		return fmt.Errorf("fake kernel has done %d actions, but storage says %d", fc.state.actions, stored)
+	}
+	fc.state.actions++
+	_, err = fc.storage(port, protocol.StorageRequest{
+		Method: "set",
+		Key:    fakeActionsKey,
+		Value:  strconv.Itoa(fc.state.actions),
+	})
+	return err
+}
+
+func (fc *fakeController) deliverInbound(action protocol.DeliverInbound) error {
+	key := "mailbox." + action.Peer
+	mailbox, err := fc.getMailbox(action.StoragePort, key)
+	if err != nil {
+		return err
+	}
+
+	// The module has already dropped what the peer acknowledged.
+	nextNum := uint64(action.Ack) + 1
+	for _, msg := range mailbox.Outbox {
+		if msg.Num >= nextNum {
+			nextNum = msg.Num + 1
+		}
+	}
+
+	// Echo each new message, and acknowledge it.
+	for _, msg := range action.Messages {
+		if uint64(msg.Num) <= mailbox.Ack {
+			continue
+		}
+		mailbox.Outbox = append(mailbox.Outbox, swingset.OutboxMessage{Num: nextNum, Body: msg.Body})
+		nextNum++
+		mailbox.Ack = uint64(msg.Num)
+	}
+
+	return fc.setMailbox(action.StoragePort, key, mailbox)
+}
+
+// The kernel stores a mailbox as JSON, which it encodes as a JSON string
+// before handing it to storage, which encodes it again to answer a get.
+func (fc *fakeController) getMailbox(port int, key string) (swingset.Mailbox, error) {
+	ret, err := fc.storage(port, protocol.StorageRequest{Method: "get", Key: key})
+	if err != nil || ret == "null" {
+		return swingset.NewMailbox(), err
+	}
+	var value string
+	if err := json.Unmarshal([]byte(ret), &value); err != nil {
+		return swingset.Mailbox{}, err
+	}
+	return swingset.ParseMailboxValue(value)
+}
+
+func (fc *fakeController) setMailbox(port int, key string, mailbox swingset.Mailbox) error {
+	_, err := fc.storage(port, protocol.StorageRequest{Method: "set", Key: key, Value: mailbox.KernelValue()})
+	return err
+}
+
+func (fc *fakeController) storage(port int, msg protocol.StorageRequest) (string, error) {
+	bz, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return fc.bridge.ReceiveFromNode(port, string(bz))
+}
@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/params"
+	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// newTestChain returns a swingset Keeper on an in-memory store, as
+// InitGenesis would leave it, and a fake controller to go with it.
+func newTestChain(t *testing.T) (sdk.Context, swingset.Keeper, *fakeController) {
+	t.Helper()
+	key := sdk.NewKVStoreKey(swingset.StoreKey)
+	paramsKey := sdk.NewKVStoreKey(params.StoreKey)
+	paramsTKey := sdk.NewTransientStoreKey(params.TStoreKey)
+
+	db := dbm.NewMemDB()
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(key, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(paramsKey, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(paramsTKey, sdk.StoreTypeTransient, db)
+	if err := ms.LoadLatestVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	cdc := codec.New()
+	swingset.RegisterCodec(cdc)
+	paramsKeeper := params.NewKeeper(cdc, paramsKey, paramsTKey, params.DefaultCodespace)
+	k := swingset.NewKeeper(nil, key, paramsKeeper.Subspace(swingset.DefaultParamspace), cdc, swingset.DefaultCodespace)
+	ctx := sdk.NewContext(ms, abci.Header{Height: 1}, false, log.NewNopLogger())
+	k.SetParams(ctx, swingset.DefaultParams())
+	k.SetStoreVersion(ctx, swingset.StoreVersion)
+	return ctx, k, newFakeController()
+}
+
+// deliverTx runs msg as the only message of a tx, which fails afterwards
+// unless keep is set, and ends the tx's savepoints as the app does.
+func deliverTx(t *testing.T, ctx sdk.Context, k swingset.Keeper, fc *fakeController, msg sdk.Msg, keep bool) sdk.Result {
+	t.Helper()
+	cacheCtx, writeCache := ctx.CacheContext()
+	res := swingset.NewHandler(k, fc.bridge)(cacheCtx, msg)
+	keep = keep && res.IsOK()
+	if keep {
+		writeCache()
+	}
+	if err := fc.bridge.EndSavepoints(context.Background(), keep); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func newDelivery(peer string, nums ...int) swingset.MsgDeliverInbound {
+	msg := swingset.MsgDeliverInbound{Peer: peer, Submitter: sdk.AccAddress("submitter")}
+	for _, num := range nums {
+		msg.Nums = append(msg.Nums, num)
+		msg.Messages = append(msg.Messages, "message")
+	}
+	return msg
+}
+
+func TestFailedTxLeavesKernelUnchanged(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 1), true); !res.IsOK() {
+		t.Fatalf("first delivery failed: %s", res.Log)
+	}
+
+	// A tx that fails after the kernel took its messages.
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 2), false); !res.IsOK() {
+		t.Fatalf("second delivery failed: %s", res.Log)
+	}
+	if fc.state.actions != 1 {
+		t.Errorf("kernel has done %d actions after a failed tx, want 1", fc.state.actions)
+	}
+
+	// The kernel rejects the messages.
+	fc.failures[protocol.TypeDeliverInbound] = 1
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 2), true); res.IsOK() {
+		t.Fatal("rejected delivery succeeded")
+	}
+	if fc.state.actions != 1 {
+		t.Errorf("kernel has done %d actions after a rejected delivery, want 1", fc.state.actions)
+	}
+
+	// The fake fails on drift, so this checks that kernel and store agree.
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 2), true); !res.IsOK() {
+		t.Fatalf("delivery after failures: %s", res.Log)
+	}
+	if got := k.GetMailbox(ctx, "peer").Ack; got != 2 {
+		t.Errorf("mailbox ack = %d, want 2", got)
+	}
+	if len(fc.savepoints) != 0 {
+		t.Errorf("%d savepoints left open", len(fc.savepoints))
+	}
+}
+
+func TestOutOfGasFailsTx(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+
+	// Enough to get the kernel going, but not for all its storage.
+	gasCtx := ctx.WithGasMeter(sdk.NewGasMeter(5000))
+	res := deliverTx(t, gasCtx, k, fc, newDelivery("peer", 1), true)
+	if res.Code != sdk.CodeOutOfGas || res.Codespace != sdk.CodespaceRoot {
+		t.Fatalf("delivery without the gas for it = %s code %d, want out of gas", res.Codespace, res.Code)
+	}
+	if fc.state.actions != 0 {
+		t.Errorf("kernel kept %d actions from a tx that ran out of gas", fc.state.actions)
+	}
+	if k.HasStorage(ctx, "mailbox.peer") {
+		t.Error("a tx that ran out of gas wrote a mailbox")
+	}
+
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 1), true); !res.IsOK() {
+		t.Fatalf("delivery with enough gas: %s", res.Log)
+	}
+}
+
+func TestControllerFailureHaltsNode(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+
+	// A kernel rejection is the tx's result, as on every validator.
+	fc.failures[protocol.TypeDeliverInbound] = 1
+	res := deliverTx(t, ctx, k, fc, newDelivery("peer", 1), true)
+	if want := swingset.ErrKernelFailed(k.Codespace(), "").Code(); res.Code != want {
+		t.Errorf("rejected delivery = code %d, want %d", res.Code, want)
+	}
+	if err := fc.bridge.Halted(); err != nil {
+		t.Fatalf("a kernel rejection halted the bridge: %s", err)
+	}
+
+	// Losing the controller is this node's alone, so the node halts.
+	fc.down[protocol.TypeDeliverInbound] = true
+	deliverTx(t, ctx, k, fc, newDelivery("peer", 1), true)
+	if fc.bridge.Halted() == nil {
+		t.Error("losing the controller didn't halt the bridge")
+	}
+}
@@ -1,18 +1,26 @@
 package daemon
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"syscall"
+	"time"
 
 	"fmt"
 	"os"
+	"os/signal"
 
+	"github.com/cosmos/cosmos-sdk/baseapp"
 	"github.com/cosmos/cosmos-sdk/server"
+	"github.com/cosmos/cosmos-sdk/store"
 	"github.com/cosmos/cosmos-sdk/x/genaccounts"
 	genaccscli "github.com/cosmos/cosmos-sdk/x/genaccounts/client/cli"
 	"github.com/cosmos/cosmos-sdk/x/staking"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/tendermint/tendermint/libs/cli"
 	"github.com/tendermint/tendermint/libs/log"
 
@@ -26,13 +34,20 @@ import (
 	"github.com/Agoric/cosmic-swingset/x/swingset"
 )
 
-type Sender func(needReply bool, str string) (string, error)
+const (
+	// FlagControllerTimeout bounds how long consensus waits for the controller.
+	FlagControllerTimeout = "controller-timeout"
+	// FlagRecord names a file to which all bridge traffic is appended.
+	FlagRecord = "record"
+)
 
 func Run() {
 	RunWithController(nil)
 }
 
-func RunWithController(sendToNode Sender) {
+// RunWithController runs the daemon connected to a controller through bridge.
+// If bridge is nil, `start --controller` chooses one.
+func RunWithController(bridge *swingset.Bridge) {
 	cobra.EnableCommandSorting = false
 
 	cdc := app.MakeCodec()
@@ -61,12 +76,20 @@ func RunWithController(sendToNode Sender) {
 		genutilcli.ValidateGenesisCmd(ctx, cdc, app.ModuleBasics),
 		// AddGenesisAccountCmd allows users to add accounts to the genesis file
 		genaccscli.AddGenesisAccountCmd(ctx, cdc, app.DefaultNodeHome, app.DefaultCLIHome),
+		ReplayCmd(ctx),
+		StoreCmd(ctx, cdc),
 	)
 
-	// FIXME: Remove this global variable!
-	swingset.NodeMessageSender = sendToNode
-
-	server.AddCommands(ctx, cdc, rootCmd, makeNewApp(sendToNode), exportAppStateAndTMValidators)
+	server.AddCommands(ctx, cdc, rootCmd, makeNewApp(ctx, bridge), exportAppStateAndTMValidators)
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Name() == "start" {
+			if bridge == nil {
+				cmd.Flags().String(FlagController, "", "SwingSet controller to use: unix:SOCKET, exec:COMMAND, or fake")
+			}
+			cmd.Flags().Duration(FlagControllerTimeout, 5*time.Minute, "Halt the node if the controller does not answer within this time (0 to wait forever)")
+			cmd.Flags().String(FlagRecord, "", "Append all SwingSet bridge traffic to this file, for replay")
+		}
+	}
 
 	// prepare and add flags
 	executor := cli.PrepareBaseCmd(rootCmd, "AG_CHAIN_COSMOS", app.DefaultNodeHome)
@@ -76,15 +99,38 @@ func RunWithController(sendToNode Sender) {
 	}
 }
 
-func makeNewApp(sendToNode Sender) func(logger log.Logger, db dbm.DB, traceStore io.Writer) abci.Application {
+func makeNewApp(ctx *server.Context, bridge *swingset.Bridge) func(logger log.Logger, db dbm.DB, traceStore io.Writer) abci.Application {
 	// fmt.Println("Constructing app!")
 	return func(logger log.Logger, db dbm.DB, traceStore io.Writer) abci.Application {
 		// fmt.Println("Starting daemon!")
-		abci := app.NewSwingSetApp(logger, db)
-		if sendToNode != nil {
-			msg := `{"type":"AG_COSMOS_INIT"}`
-			// fmt.Println("Sending to Node", msg)
-			_, err := sendToNode(true, msg)
+		bridge := bridge
+		if bridge == nil {
+			var err error
+			bridge, err = newBridge(viper.GetString(FlagController), logger)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Cannot create controller", err)
+				os.Exit(1)
+			}
+		}
+		bridge.SetLogger(logger.With("module", "x/"+swingset.ModuleName))
+		if instrumentation := ctx.Config.Instrumentation; instrumentation.Prometheus {
+			bridge.SetMetrics(swingset.PrometheusMetrics(instrumentation.Namespace))
+		}
+		if path := viper.GetString(FlagRecord); path != "" {
+			recorder, err := swingset.OpenRecorder(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Cannot record", err)
+				os.Exit(1)
+			}
+			bridge.SetRecorder(recorder)
+			closeOnSignal(recorder, logger)
+		}
+		// Keep the states that --pruning asks for, so they can be queried.
+		pruning := store.NewPruningOptionsFromString(viper.GetString("pruning"))
+		abci := app.NewSwingSetApp(bridge, logger, db, baseapp.SetPruning(pruning))
+		if bridge != nil {
+			bridge.StartWatchdog(viper.GetDuration(FlagControllerTimeout), haltNode(logger))
+			err := bridge.Init(context.Background())
 			// fmt.Println("Received AG_COSMOS_INIT response", ret, err)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, "Cannot initialize Node", err)
@@ -95,12 +141,52 @@ func makeNewApp(sendToNode Sender) func(logger log.Logger, db dbm.DB, traceStore
 	}
 }
 
+// closeOnSignal closes the recorder when the node is told to stop, so that
+// the recording is complete and on disk.
+func closeOnSignal(recorder *swingset.Recorder, logger log.Logger) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := recorder.Close(); err != nil {
+			logger.Error("cannot close recording", "err", err)
+		}
+	}()
+}
+
+// newBridge connects to the controller named by spec.
+func newBridge(spec string, logger log.Logger) (*swingset.Bridge, error) {
+	switch spec {
+	case "":
+		return nil, errors.New("no SwingSet controller; use --controller")
+	case "fake":
+		return NewFakeBridge(), nil
+	default:
+		return NewControllerBridge(spec, logger)
+	}
+}
+
+// haltNode stops the node the same way an operator's Ctrl-C would, so that
+// Tendermint shuts down cleanly instead of waiting forever on a block.
+func haltNode(logger log.Logger) func(error) {
+	return func(err error) {
+		logger.Error("halting node", "err", err)
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(syscall.SIGTERM)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
 func exportAppStateAndTMValidators(
 	logger log.Logger, db dbm.DB, traceStore io.Writer, height int64, forZeroHeight bool, jailWhiteList []string,
 ) (json.RawMessage, []tmtypes.GenesisValidator, error) {
 
 	if height != -1 {
-		ssApp := app.NewSwingSetApp(logger, db)
+		ssApp := app.NewSwingSetApp(nil, logger, db)
 		err := ssApp.LoadHeight(height)
 		if err != nil {
 			return nil, nil, err
@@ -108,7 +194,7 @@ func exportAppStateAndTMValidators(
 		return ssApp.ExportAppStateAndValidators(forZeroHeight, jailWhiteList)
 	}
 
-	ssApp := app.NewSwingSetApp(logger, db)
+	ssApp := app.NewSwingSetApp(nil, logger, db)
 
 	return ssApp.ExportAppStateAndValidators(forZeroHeight, jailWhiteList)
 }
@@ -0,0 +1,352 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/server"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+const flagReplayHeight = "height"
+
+// replayedAction is a recorded call to the controller, together with the
+// storage requests it caused and the reply it got.
+type replayedAction struct {
+	call     swingset.Record
+	header   replayedHeader
+	gas      *swingset.Record
+	receives []replayedReceive
+	reply    *swingset.Record
+	// The block the action was part of.  Savepoints are in the block of the
+	// action they enclose.
+	height int64
+}
+
+// replayedReceive is a recorded request to a port, and the reply it got if
+// the recording has one.
+type replayedReceive struct {
+	request swingset.Record
+	reply   *swingset.Record
+}
+
+type replayedHeader struct {
+	Type        string `json:"type"`
+	StoragePort int    `json:"storagePort"`
+	BlockHeight int64  `json:"blockHeight"`
+	Peer        string `json:"peer"`
+	Ack         int    `json:"ack"`
+}
+
+// replayer replays actions against the keeper, keeping their writes exactly
+// when the recorded savepoints say the chain kept them.
+type replayer struct {
+	ctx    sdk.Context
+	keeper swingset.Keeper
+	// The state under each open savepoint, innermost last
+	savepoints  []replayedSavepoint
+	actions     int
+	receives    int
+	divergences int
+}
+
+type replayedSavepoint struct {
+	ctx   sdk.Context
+	write func()
+}
+
+// ReplayCmd replays bridge traffic recorded by `start --record`.
+func ReplayCmd(ctx *server.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay [recording]",
+		Short: "Replay recorded SwingSet bridge traffic offline",
+		Long: `Replay bridge traffic recorded by 'start --record' against this node's
+stopped state, and report every answer that differs from the recording.
+
+Only the actions for the block at --height are replayed, starting from the
+state committed at the previous height.  As on the chain, outboxes are
+trimmed before deliveries, storage is charged to the recorded gas meters,
+and writes are kept only under savepoints that were committed.  Nothing is
+ever written back.
+
+By default, the recorded storage requests are fed to the swingset keeper
+alone.  With --controller, the recorded actions are sent to that controller
+instead, and its storage requests are answered by the keeper.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			records, err := swingset.ReadRecording(args[0])
+			if err != nil {
+				return err
+			}
+			actions, err := groupRecords(records)
+			if err != nil {
+				return err
+			}
+
+			height := viper.GetInt64(flagReplayHeight)
+			if height < 2 {
+				// The state before the first block isn't kept.
+				return fmt.Errorf("--%s must be 2 or more", flagReplayHeight)
+			}
+
+			var bridge *swingset.Bridge
+			if spec := viper.GetString(FlagController); spec != "" {
+				bridge, err = newBridge(spec, ctx.Logger)
+				if err != nil {
+					return err
+				}
+				if err := bridge.Init(context.Background()); err != nil {
+					return err
+				}
+			}
+
+			return withSwingSetState(ctx, height-1, func(sctx sdk.Context, keeper swingset.Keeper) error {
+				rp := &replayer{ctx: sctx.WithBlockHeight(height), keeper: keeper}
+				if err := rp.replayBlock(bridge, actions, height); err != nil {
+					return err
+				}
+
+				fmt.Printf("replayed %d actions and %d storage requests: %d divergences\n",
+					rp.actions, rp.receives, rp.divergences)
+				if rp.divergences > 0 {
+					return fmt.Errorf("replay diverged from %s", args[0])
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().String(FlagController, "", "Replay actions against this controller: unix:SOCKET, exec:COMMAND, or fake")
+	cmd.Flags().Int64(flagReplayHeight, 0, "Replay the actions for this block height")
+	_ = cmd.MarkFlagRequired(flagReplayHeight)
+	return cmd
+}
+
+// groupRecords gathers records by the call that caused them, and matches each
+// request to a port with the reply from that port that follows it.
+func groupRecords(records []swingset.Record) ([]*replayedAction, error) {
+	var actions []*replayedAction
+	var current *replayedAction
+	// The unanswered request to each port, by its index in current.receives
+	pending := make(map[int]int)
+	// The gas meter for each storage port not yet given to an action
+	gas := make(map[int]swingset.Record)
+	for i, rec := range records {
+		switch rec.Kind {
+		case swingset.RecordGas:
+			gas[rec.Port] = rec
+		case swingset.RecordCall:
+			current = &replayedAction{call: rec}
+			if err := json.Unmarshal([]byte(rec.Body), &current.header); err != nil {
+				return nil, fmt.Errorf("record %d: malformed action: %s", i, err)
+			}
+			if meter, ok := gas[current.header.StoragePort]; ok {
+				current.gas = &meter
+				delete(gas, current.header.StoragePort)
+			}
+			actions = append(actions, current)
+			pending = make(map[int]int)
+		case swingset.RecordReceive:
+			if current != nil {
+				pending[rec.Port] = len(current.receives)
+				current.receives = append(current.receives, replayedReceive{request: rec})
+			}
+		case swingset.RecordReceiveReply:
+			if current == nil {
+				continue
+			}
+			j, ok := pending[rec.Port]
+			if !ok {
+				return nil, fmt.Errorf("record %d: reply from port %d to no request", i, rec.Port)
+			}
+			delete(pending, rec.Port)
+			reply := rec
+			current.receives[j].reply = &reply
+		case swingset.RecordReply:
+			if current != nil && rec.ReplyPort == current.call.ReplyPort {
+				reply := rec
+				current.reply = &reply
+			}
+		}
+	}
+	if err := assignHeights(actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// assignHeights puts each savepoint in the block of the action it encloses,
+// which is the next action to have a block height.
+func assignHeights(actions []*replayedAction) error {
+	var height int64
+	for i := len(actions) - 1; i >= 0; i-- {
+		if actions[i].header.Type != protocol.TypeSavepoint {
+			height = actions[i].header.BlockHeight
+		}
+		actions[i].height = height
+	}
+	var open []*replayedAction
+	for _, action := range actions {
+		switch action.header.Type {
+		case protocol.TypeSavepoint:
+			open = append(open, action)
+		case protocol.TypeCommitSavepoint, protocol.TypeAbortSavepoint:
+			if len(open) == 0 {
+				return fmt.Errorf("%s with no savepoint open: %s", action.header.Type, action.call.Body)
+			}
+			action.height = open[len(open)-1].height
+			open = open[:len(open)-1]
+		}
+	}
+	return nil
+}
+
+// replayBlock replays the actions for the block at height, in order.
+func (rp *replayer) replayBlock(bridge *swingset.Bridge, actions []*replayedAction, height int64) error {
+	// Any migration happens before the block's first action.
+	rp.keeper.MigrateStore(rp.ctx)
+	for _, action := range actions {
+		if action.header.Type == protocol.TypeInit || action.height != height {
+			continue
+		}
+		savepoint := rp.replaySavepoint(action)
+		if bridge != nil {
+			if err := rp.replayAction(bridge, action); err != nil {
+				return err
+			}
+		} else if !savepoint {
+			if err := rp.replayStorage(action); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rp.savepoints) != 0 {
+		return fmt.Errorf("%d savepoints left open at the end of block %d", len(rp.savepoints), height)
+	}
+	return nil
+}
+
+// replaySavepoint sets, commits or aborts a savepoint in the replayed state,
+// as the chain did with the kernel, and says whether action was one.
+func (rp *replayer) replaySavepoint(action *replayedAction) bool {
+	switch action.header.Type {
+	case protocol.TypeSavepoint:
+		cacheCtx, write := rp.ctx.CacheContext()
+		rp.savepoints = append(rp.savepoints, replayedSavepoint{ctx: rp.ctx, write: write})
+		rp.ctx = cacheCtx
+	case protocol.TypeCommitSavepoint, protocol.TypeAbortSavepoint:
+		// assignHeights checked that one is open.
+		sp := rp.savepoints[len(rp.savepoints)-1]
+		rp.savepoints = rp.savepoints[:len(rp.savepoints)-1]
+		if action.header.Type == protocol.TypeCommitSavepoint {
+			sp.write()
+		}
+		rp.ctx = sp.ctx
+	default:
+		return false
+	}
+	return true
+}
+
+// storageHandler answers action's storage requests from the replayed state,
+// charging them as they were charged when recorded, after doing what the
+// chain did before sending the action.
+func (rp *replayer) storageHandler(action *replayedAction) (swingset.PortHandler, error) {
+	storageHandler := swingset.NewStorageHandler(rp.ctx, rp.keeper)
+	if gas := action.gas; gas != nil {
+		if gas.GasLimit > 0 {
+			storageHandler.GasMeter = sdk.NewGasMeter(gas.GasLimit)
+		} else {
+			storageHandler.GasMeter = sdk.NewInfiniteGasMeter()
+		}
+		storageHandler.GasMeter.ConsumeGas(gas.GasConsumed, "replayed")
+	}
+	if action.header.Type == protocol.TypeDeliverInbound {
+		if _, err := storageHandler.TrimOutbox(action.header.Peer, uint64(action.header.Ack)); err != nil {
+			return nil, fmt.Errorf("cannot trim outbox of %s: %s", action.header.Peer, err)
+		}
+	}
+	return storageHandler, nil
+}
+
+func (rp *replayer) diverged(action *replayedAction, what string, recorded, got swingset.Record) {
+	if recorded.Body == got.Body && recorded.Error == got.Error {
+		return
+	}
+	rp.divergences++
+	fmt.Printf("height %d %s: %s differs\n  recorded: %q %q\n  replayed: %q %q\n",
+		action.height, action.header.Type, what,
+		recorded.Body, recorded.Error, got.Body, got.Error)
+}
+
+// replayStorage feeds an action's recorded storage requests to the keeper.
+func (rp *replayer) replayStorage(action *replayedAction) error {
+	rp.actions++
+	handler, err := rp.storageHandler(action)
+	if err != nil {
+		return err
+	}
+	for _, receive := range action.receives {
+		if receive.request.Port != action.header.StoragePort {
+			continue
+		}
+		rp.receives++
+		out, err := handler.Receive(receive.request.Body)
+		if receive.reply == nil {
+			// The recording ends before the reply.
+			continue
+		}
+		got := swingset.Record{Body: out, Error: swingset.ErrorString(err)}
+		rp.diverged(action, fmt.Sprintf("storage request %s", receive.request.Body), *receive.reply, got)
+	}
+	return nil
+}
+
+// replayAction sends a recorded action to a controller, whose storage
+// requests are answered by the keeper.
+func (rp *replayer) replayAction(bridge *swingset.Bridge, action *replayedAction) error {
+	rp.actions++
+	handler, err := rp.storageHandler(action)
+	if err != nil {
+		return err
+	}
+	counter := &countingHandler{PortHandler: handler}
+	port := bridge.RegisterPortHandler(counter)
+	defer bridge.UnregisterPortHandler(port)
+
+	// Point the action at our storage port instead of the recorded one.
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(action.call.Body), &body); err != nil {
+		return err
+	}
+	if _, ok := body["storagePort"]; ok {
+		body["storagePort"] = port
+	}
+	bz, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	out, err := bridge.CallToNode(context.Background(), string(bz))
+	rp.receives += counter.count
+	if action.reply != nil {
+		got := swingset.Record{Body: out, Error: swingset.ErrorString(err)}
+		rp.diverged(action, "reply", *action.reply, got)
+	}
+	return nil
+}
+
+type countingHandler struct {
+	swingset.PortHandler
+	count int
+}
+
+func (ch *countingHandler) Receive(str string) (string, error) {
+	ch.count++
+	return ch.PortHandler.Receive(str)
+}
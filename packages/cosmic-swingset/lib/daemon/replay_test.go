@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+)
+
+func TestReplayFakeRecording(t *testing.T) {
+	ctx, k, fc := newTestChain(t)
+
+	// Leave the peer an outbox for the block's deliveries to trim.
+	if res := deliverTx(t, ctx, k, fc, newDelivery("peer", 1, 2), true); !res.IsOK() {
+		t.Fatalf("delivery before recording: %s", res.Log)
+	}
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording")
+	recorder, err := swingset.OpenRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.bridge.SetRecorder(recorder)
+
+	// Run a block on top of ctx, which replay then starts from.
+	blockCtx, _ := ctx.CacheContext()
+	blockCtx = blockCtx.WithBlockHeight(2)
+	if halted := beginBlock(blockCtx, k, fc); halted != nil {
+		t.Fatalf("BeginBlock halted: %v", halted)
+	}
+	delivery := newDelivery("peer", 3)
+	delivery.Ack = 1
+	if res := deliverTx(t, blockCtx, k, fc, delivery, true); !res.IsOK() {
+		t.Fatalf("delivery: %s", res.Log)
+	}
+	// A tx that fails after the delivery, whose trim and storage are undone.
+	delivery = newDelivery("peer", 4)
+	delivery.Ack = 2
+	if res := deliverTx(t, blockCtx, k, fc, delivery, false); !res.IsOK() {
+		t.Fatalf("delivery in a failed tx: %s", res.Log)
+	}
+	// A tx that had already used some gas, and runs out.
+	meter := sdk.NewGasMeter(6000)
+	meter.ConsumeGas(1000, "tx")
+	if res := deliverTx(t, blockCtx.WithGasMeter(meter), k, fc, delivery, true); res.Code != sdk.CodeOutOfGas {
+		t.Fatalf("delivery without the gas for it = code %d, want out of gas", res.Code)
+	}
+	if res := deliverTx(t, blockCtx, k, fc, delivery, true); !res.IsOK() {
+		t.Fatalf("delivery: %s", res.Log)
+	}
+
+	fc.bridge.SetRecorder(nil)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := swingset.ReadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := groupRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp := &replayer{ctx: ctx.WithBlockHeight(2), keeper: k}
+	if err := rp.replayBlock(nil, actions, 2); err != nil {
+		t.Fatal(err)
+	}
+	if rp.divergences != 0 {
+		t.Errorf("replay found %d divergences, want none", rp.divergences)
+	}
+	if rp.actions != 5 || rp.receives == 0 {
+		t.Errorf("replayed %d actions and %d storage requests, want 5 actions", rp.actions, rp.receives)
+	}
+	for _, path := range []string{"mailbox.peer", fakeActionsKey} {
+		if got, want := k.GetStorage(rp.ctx, path), k.GetStorage(blockCtx, path); got != want {
+			t.Errorf("replayed %s = %q, want %q", path, got.Value, want.Value)
+		}
+	}
+}
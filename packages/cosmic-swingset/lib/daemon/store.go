@@ -0,0 +1,242 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/cosmos/cosmos-sdk/baseapp"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/server"
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	abci "github.com/tendermint/tendermint/abci/types"
+
+	app "github.com/Agoric/cosmic-swingset"
+	"github.com/Agoric/cosmic-swingset/x/swingset"
+)
+
+const (
+	flagStoreHeight = "height"
+)
+
+// storeDump is a subtree of swingset storage, as written by `store dump`.
+type storeDump struct {
+	Path    string                  `json:"path"`
+	Height  int64                   `json:"height"`
+	Entries []swingset.StorageEntry `json:"entries"`
+}
+
+// StoreCmd inspects the swingset store of a stopped node.
+func StoreCmd(ctx *server.Context, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "store",
+		Short: "Inspect, check, dump and load the swingset store of a stopped node",
+	}
+	cmd.AddCommand(
+		withStoreHeightFlag(storeListCmd(ctx)),
+		withStoreHeightFlag(storeGetCmd(ctx)),
+		withStoreHeightFlag(storeDumpCmd(ctx)),
+		withStoreHeightFlag(storeCheckCmd(ctx)),
+		storeLoadCmd(ctx),
+	)
+	return cmd
+}
+
+func withStoreHeightFlag(cmd *cobra.Command) *cobra.Command {
+	cmd.Flags().Int64(flagStoreHeight, 0, "Use the state committed at this height instead of the latest")
+	return cmd
+}
+
+// withSwingSetStore calls fn with the swingset keeper and a context for the
+// state of this node at --height, or its latest state.
+func withSwingSetStore(ctx *server.Context, fn func(sdk.Context, swingset.Keeper) error) error {
+	return withSwingSetState(ctx, viper.GetInt64(flagStoreHeight), fn)
+}
+
+// withSwingSetState is withSwingSetStore for the state at height, or the
+// latest state if height is zero.
+func withSwingSetState(ctx *server.Context, height int64, fn func(sdk.Context, swingset.Keeper) error) error {
+	db, err := sdk.NewLevelDB("application", filepath.Join(ctx.Config.RootDir, "data"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Keep hold of the app's store, to read it at any retained version.
+	cms := store.NewCommitMultiStore(db)
+	ssApp := app.NewSwingSetApp(nil, ctx.Logger, db, func(bapp *baseapp.BaseApp) {
+		bapp.SetCMS(cms)
+	})
+
+	if height == 0 {
+		height = ssApp.LastBlockHeight()
+	}
+	ms, err := cms.CacheMultiStoreWithVersion(height)
+	if err != nil {
+		return fmt.Errorf("cannot load state at height %d (was it pruned?): %s", height, err)
+	}
+	sctx := sdk.NewContext(ms, abci.Header{Height: height}, true, ctx.Logger)
+	return fn(sctx, ssApp.SwingSetKeeper())
+}
+
+func storeListCmd(ctx *server.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list [path]",
+		Short: "List the children of a storage path",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+			return withSwingSetStore(ctx, func(sctx sdk.Context, keeper swingset.Keeper) error {
+				for _, key := range keeper.GetKeys(sctx, path).Keys {
+					fmt.Println(key)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+func storeGetCmd(ctx *server.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get [path]",
+		Short: "Print the value at a storage path",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withSwingSetStore(ctx, func(sctx sdk.Context, keeper swingset.Keeper) error {
+				if !keeper.HasStorage(sctx, args[0]) {
+					return fmt.Errorf("no value at %s", args[0])
+				}
+				fmt.Println(keeper.GetStorage(sctx, args[0]).Value)
+				return nil
+			})
+		},
+	}
+}
+
+func storeDumpCmd(ctx *server.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "dump [path]",
+		Short: "Print a storage subtree as JSON",
+		Long: `Print the values at and under a storage path as JSON, or all of
+storage if no path is given.  The output can be given to 'store load'.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+			return withSwingSetStore(ctx, func(sctx sdk.Context, keeper swingset.Keeper) error {
+				dump := storeDump{Path: path, Height: sctx.BlockHeight(), Entries: keeper.GetSubtree(sctx, path)}
+				if dump.Entries == nil {
+					dump.Entries = []swingset.StorageEntry{}
+				}
+				bz, err := json.MarshalIndent(dump, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(bz))
+				return nil
+			})
+		},
+	}
+}
+
+func storeCheckCmd(ctx *server.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check [route]",
+		Short: "Check the invariants of the swingset store, or just the one at route",
+		Long: `Check the invariants of the swingset store, or just the one at route, and
+fail if any are broken.  The routes are storage-index, child-index, mailboxes
+and peer-index.  This works on a node that has halted because of a broken
+invariant.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var route string
+			if len(args) > 0 {
+				route = args[0]
+			}
+			return withSwingSetStore(ctx, func(sctx sdk.Context, keeper swingset.Keeper) error {
+				results, err := keeper.CheckInvariants(sctx, route)
+				if err != nil {
+					return err
+				}
+				out := swingset.InvariantResults{Results: results, Height: sctx.BlockHeight()}
+				fmt.Println(out)
+				if out.Broken() {
+					return fmt.Errorf("swingset invariants are broken at height %d", out.Height)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+func storeLoadCmd(ctx *server.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "load [dump-file]",
+		Short: "Load a storage subtree into the swingset store",
+		Long: `Replace a storage subtree in this node's swingset store with one written
+by 'store dump', and commit the result as the next height.
+
+The node's state then no longer matches the chain's blocks, so Tendermint
+won't start it again; this is for repairing a store to inspect and check it
+offline.  Genesis files can't hold swingset storage, so this is the only way
+to import it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bz, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var dump storeDump
+			if err := json.Unmarshal(bz, &dump); err != nil {
+				return fmt.Errorf("cannot parse %s: %s", args[0], err)
+			}
+			for _, entry := range dump.Entries {
+				if !swingset.Within(entry.Path, dump.Path) {
+					return fmt.Errorf("%s is not under %s", entry.Path, dump.Path)
+				}
+			}
+			return loadIntoStore(ctx, dump)
+		},
+	}
+	return cmd
+}
+
+// loadIntoStore replaces the subtree at dump.Path in the latest state with
+// dump, and commits it.
+func loadIntoStore(ctx *server.Context, dump storeDump) error {
+	db, err := sdk.NewLevelDB("application", filepath.Join(ctx.Config.RootDir, "data"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cms := store.NewCommitMultiStore(db)
+	ssApp := app.NewSwingSetApp(nil, ctx.Logger, db, func(bapp *baseapp.BaseApp) {
+		bapp.SetCMS(cms)
+	})
+	keeper := ssApp.SwingSetKeeper()
+
+	ms := cms.CacheMultiStore()
+	sctx := sdk.NewContext(ms, abci.Header{Height: ssApp.LastBlockHeight() + 1}, false, ctx.Logger)
+	for _, entry := range keeper.GetSubtree(sctx, dump.Path) {
+		keeper.DeleteStorage(sctx, entry.Path)
+	}
+	for _, entry := range dump.Entries {
+		if err := keeper.SetStorage(sctx, entry.Path, swingset.Storage{Value: entry.Value}); err != nil {
+			return fmt.Errorf("cannot load %s: %s", entry.Path, err)
+		}
+	}
+	ms.Write()
+	commitID := cms.Commit()
+	fmt.Printf("loaded %d entries under %q, committed at height %d\n", len(dump.Entries), dump.Path, commitID.Version)
+	return nil
+}
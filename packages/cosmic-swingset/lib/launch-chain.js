@@ -47,7 +47,72 @@ async function buildSwingset(withSES, mailboxState, storage, vatsDir, argv) {
   return { controller, mb, mbs, timer };
 }
 
-export async function launch(kernelStateDBDir, mailboxStorage, vatsDir, argv) {
+// journalStorage wraps a swing store's storage so that its writes can be
+// undone.  Each open savepoint has an undo log of the values its writes
+// replaced, undefined for keys that were missing.
+function journalStorage(storage) {
+  const undoLogs = [];
+
+  function remember(key) {
+    const undo = undoLogs[undoLogs.length - 1];
+    if (undo && !undo.has(key)) {
+      undo.set(key, storage.get(key));
+    }
+  }
+
+  const journaled = {
+    has: key => storage.has(key),
+    getKeys: (start, end) => storage.getKeys(start, end),
+    get: key => storage.get(key),
+    set(key, value) {
+      remember(key);
+      storage.set(key, value);
+    },
+    delete(key) {
+      remember(key);
+      storage.delete(key);
+    },
+  };
+
+  function open() {
+    undoLogs.push(new Map());
+  }
+
+  function commit() {
+    const undo = undoLogs.pop();
+    const outer = undoLogs[undoLogs.length - 1];
+    if (outer) {
+      for (const [key, value] of undo) {
+        if (!outer.has(key)) {
+          outer.set(key, value);
+        }
+      }
+    }
+  }
+
+  // Returns whether the savepoint changed anything.
+  function abort() {
+    const undo = undoLogs.pop();
+    for (const [key, value] of undo) {
+      if (value === undefined) {
+        storage.delete(key);
+      } else {
+        storage.set(key, value);
+      }
+    }
+    return undo.size > 0;
+  }
+
+  return { storage: journaled, open, commit, abort };
+}
+
+export async function launch(
+  kernelStateDBDir,
+  mailboxStorage,
+  vatsDir,
+  argv,
+  openSavepoints = [],
+) {
   const withSES = true;
 
   console.log(
@@ -59,46 +124,112 @@ export async function launch(kernelStateDBDir, mailboxStorage, vatsDir, argv) {
     : {};
 
   const { storage, commit } = openSwingStore(kernelStateDBDir);
+  const journal = journalStorage(storage);
 
+  let controller;
+  let mb;
+  let mbs;
+  let timer;
+  async function build(state) {
+    ({ controller, mb, mbs, timer } = await buildSwingset(
+      withSES,
+      state,
+      journal.storage,
+      vatsDir,
+      argv,
+    ));
+  }
+
+  // The kernel's state in memory can't be rolled back, so after an abort the
+  // swingset is rebuilt from the restored storage, as on a restart, with the
+  // mailbox state kept here.
+  let staleMailboxData;
+  async function rebuildIfStale() {
+    if (staleMailboxData !== undefined) {
+      console.log(`rebuilding swingset after an aborted savepoint`);
+      await build(JSON.parse(staleMailboxData));
+      staleMailboxData = undefined;
+    }
+  }
+
+  function currentMailboxData() {
+    if (staleMailboxData !== undefined) {
+      return staleMailboxData;
+    }
+    return djson.stringify(mbs ? mbs.exportToData() : mailboxState);
+  }
+
+  // Savepoints still open, innermost last, with the mailbox state to return
+  // to.  Savepoints Go set before we were launched are opened first, so
+  // that building the swingset can be undone too.
+  const savepoints = [];
+  function setSavepoint(id) {
+    journal.open();
+    savepoints.push({ id, mailboxData: currentMailboxData() });
+  }
+  function popSavepoint(id) {
+    const sp = savepoints[savepoints.length - 1];
+    if (!sp || sp.id !== id) {
+      throw new Error(`savepoint ${id} is not the innermost one open`);
+    }
+    return savepoints.pop();
+  }
+
+  for (const id of openSavepoints) {
+    setSavepoint(id);
+  }
   console.log(`buildSwingset`);
-  const { controller, mb, mbs, timer } = await buildSwingset(
-    withSES,
-    mailboxState,
-    storage,
-    vatsDir,
-    argv,
-  );
+  await build(mailboxState);
 
   function saveState() {
-    // save kernel state to the swing store, and the mailbox state to a cosmos
-    // kvstore where it can be queried externally
-    commit();
+    // save kernel state to the swing store, unless a savepoint might yet
+    // undo it, and the mailbox state to a cosmos kvstore where it can be
+    // queried externally
+    if (savepoints.length === 0) {
+      commit();
+    }
     const mailboxStateData = djson.stringify(mbs.exportToData());
     mailboxStorage.set(`mailbox`, mailboxStateData);
     return mailboxStateData.length;
   }
 
+  function commitSavepoint(id) {
+    popSavepoint(id);
+    journal.commit();
+    if (savepoints.length === 0) {
+      commit();
+    }
+  }
+
+  function abortSavepoint(id) {
+    const { mailboxData } = popSavepoint(id);
+    if (journal.abort() || currentMailboxData() !== mailboxData) {
+      staleMailboxData = mailboxData;
+    }
+  }
+
   // save the initial state immediately
   saveState();
 
   // then arrange for inbound messages to be processed, after which we save
   async function turnCrank() {
-    const oldData = djson.stringify(mbs.exportToData());
     let start = Date.now();
     await controller.run();
     const runTime = Date.now() - start;
     // now check mbs
     start = Date.now();
     const newState = mbs.exportToData();
-    const newData = djson.stringify(newState);
-    if (newData !== oldData) {
-      console.log(`outbox changed`);
-      for (const peer of Object.getOwnPropertyNames(newState)) {
-        const data = {
-          outbox: newState[peer].outbox,
-          ack: newState[peer].inboundAck,
-        };
-        mailboxStorage.set(`mailbox.${peer}`, djson.stringify(data));
+    for (const peer of Object.getOwnPropertyNames(newState)) {
+      const data = djson.stringify({
+        outbox: newState[peer].outbox,
+        ack: newState[peer].inboundAck,
+      });
+      // Only rewrite the mailboxes that changed.  What's stored is what
+      // every validator has, unlike anything remembered here, which a
+      // restart or an aborted savepoint would lose.
+      if (mailboxStorage.get(`mailbox.${peer}`) !== data) {
+        console.log(`mailbox for ${peer} changed`);
+        mailboxStorage.set(`mailbox.${peer}`, data);
       }
     }
     const mbTime = Date.now() - start;
@@ -114,6 +245,7 @@ export async function launch(kernelStateDBDir, mailboxStorage, vatsDir, argv) {
     if (!(messages instanceof Array)) {
       throw new Error(`inbound given non-Array: ${messages}`);
     }
+    await rebuildIfStale();
     if (mb.deliverInbound(sender, messages, ack)) {
       console.log(`mboxDeliver:   ADDED messages`);
       await turnCrank();
@@ -121,6 +253,7 @@ export async function launch(kernelStateDBDir, mailboxStorage, vatsDir, argv) {
   }
 
   async function deliverStartBlock(blockHeight, blockTime) {
+    await rebuildIfStale();
     const addedToQueue = timer.poll(blockTime);
     console.log(
       `polled; blockTime:${blockTime}, h:${blockHeight} ADDED: ${addedToQueue}`,
@@ -128,5 +261,11 @@ export async function launch(kernelStateDBDir, mailboxStorage, vatsDir, argv) {
     await turnCrank();
   }
 
-  return { deliverInbound, deliverStartBlock };
+  return {
+    deliverInbound,
+    deliverStartBlock,
+    setSavepoint,
+    commitSavepoint,
+    abortSavepoint,
+  };
 }
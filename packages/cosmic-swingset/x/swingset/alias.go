@@ -9,6 +9,14 @@ const (
 	ModuleName = types.ModuleName
 	RouterKey  = types.RouterKey
 	StoreKey   = types.StoreKey
+
+	DefaultParamspace = types.DefaultParamspace
+	DefaultCodespace  = types.DefaultCodespace
+	PolicyHalt        = types.PolicyHalt
+	PolicyRetry       = types.PolicyRetry
+	PolicyDegrade     = types.PolicyDegrade
+
+	StoreVersion = keeper.StoreVersion
 )
 
 var (
@@ -20,6 +28,41 @@ var (
 	NewKeys          = types.NewKeys
 	ModuleCdc        = types.ModuleCdc
 	RegisterCodec    = types.RegisterCodec
+	NewParams        = types.NewParams
+	DefaultParams    = types.DefaultParams
+	ParamKeyTable    = types.ParamKeyTable
+	NewQueryKeysParams = types.NewQueryKeysParams
+	NewQueryPeersParams = types.NewQueryPeersParams
+	NewQueryTreeParams  = types.NewQueryTreeParams
+	NewPeerInfo        = types.NewPeerInfo
+	NewQueryResMailbox = types.NewQueryResMailbox
+	ParseMailboxValue  = types.ParseMailboxValue
+	PeerFromIterator   = keeper.PeerFromIterator
+	Within             = keeper.Within
+	RegisterInvariants = keeper.RegisterInvariants
+
+	ErrMalformedMessage      = types.ErrMalformedMessage
+	ErrKernelFailed          = types.ErrKernelFailed
+	ErrResourceExhausted     = types.ErrResourceExhausted
+	ErrKernelDegraded        = types.ErrKernelDegraded
+	ErrControllerUnavailable = types.ErrControllerUnavailable
+	ErrQuotaExceeded         = types.ErrQuotaExceeded
+	ErrInvalidPath           = types.ErrInvalidPath
+	ErrInvalidMailbox        = types.ErrInvalidMailbox
+
+	EventTypeBeginBlockFailed = types.EventTypeBeginBlockFailed
+	EventTypeKernelDegraded   = types.EventTypeKernelDegraded
+	EventTypeKernelRecovered  = types.EventTypeKernelRecovered
+	EventTypeStorage          = types.EventTypeStorage
+	AttributeKeyPolicy        = types.AttributeKeyPolicy
+	AttributeKeyAttempts      = types.AttributeKeyAttempts
+	AttributeKeyCorrelationID = types.AttributeKeyCorrelationID
+	AttributeKeyHeight        = types.AttributeKeyHeight
+	AttributeKeyPath          = types.AttributeKeyPath
+	AttributeKeyValue         = types.AttributeKeyValue
+	AttributeKeyValueHash     = types.AttributeKeyValueHash
+	AttributeKeyDeleted       = types.AttributeKeyDeleted
+	AttributeValueCategory    = types.AttributeValueCategory
 )
 
 type (
@@ -28,4 +71,30 @@ type (
 	QueryResStorage = types.QueryResStorage
 	QueryResKeys    = types.QueryResKeys
 	Storage         = types.Storage
+	StorageChange   = types.StorageChange
+	StorageEntry    = types.StorageEntry
+	PeerInfo        = types.PeerInfo
+	Mailbox         = types.Mailbox
+	OutboxMessage   = types.OutboxMessage
+	QueryResMailbox = types.QueryResMailbox
+	QueryResPeers   = types.QueryResPeers
+	QueryResHas     = types.QueryResHas
+	QueryResSize    = types.QueryResSize
+	QueryResEntries = types.QueryResEntries
+	QueryResValues  = types.QueryResValues
+	QueryResTree    = types.QueryResTree
+	QueryTreeParams = types.QueryTreeParams
+	KeyValue        = types.KeyValue
+	StorageTree     = types.StorageTree
+	Params          = types.Params
+	GasSchedule     = types.GasSchedule
+	KernelStatus    = types.KernelStatus
+	StorageQuota    = types.StorageQuota
+	StorageUsage    = types.StorageUsage
+	NamespaceUsage  = types.NamespaceUsage
+	QueryResUsage   = types.QueryResUsage
+	QueryResStatus  = types.QueryResStatus
+	InvariantResults = types.InvariantResults
+	InvariantResult = types.InvariantResult
+	QueryKeysParams = types.QueryKeysParams
 )
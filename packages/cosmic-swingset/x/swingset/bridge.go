@@ -0,0 +1,363 @@
+package swingset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// Transport delivers a message to the controller.  If replyPort is nonzero,
+// the controller's answer must be handed back to Bridge.Reply with that port.
+type Transport func(replyPort int, str string) error
+
+// PortHandler answers messages the controller sends to a registered port.
+type PortHandler interface {
+	Receive(string) (string, error)
+}
+
+// ErrControllerStalled is returned for calls the watchdog gave up on.
+var ErrControllerStalled = errors.New("controller did not answer")
+
+type bridgeReply struct {
+	str string
+	err error
+}
+
+type outstandingCall struct {
+	ch      chan bridgeReply
+	started time.Time
+}
+
+// Bridge connects the swingset module to its controller.  It owns the table
+// of calls awaiting a reply and the registry of ports the controller may send
+// to, and is safe to use from consensus goroutines and transport callbacks.
+type Bridge struct {
+	transport Transport
+
+	mu        sync.Mutex
+	replies   map[int]*outstandingCall
+	lastReply int
+	ports     map[int]PortHandler
+	lastPort  int
+	halted    error
+	session   protocol.Session
+	recorder  *Recorder
+	metrics   *Metrics
+	logger    log.Logger
+
+	lastSavepoint int
+	savepoints    []*Savepoint
+
+	abandon func(replyPort int)
+}
+
+// NewBridge creates a Bridge that sends through transport.
+func NewBridge(transport Transport) *Bridge {
+	return &Bridge{
+		transport: transport,
+		replies:   map[int]*outstandingCall{},
+		ports:     map[int]PortHandler{},
+		metrics:   NopMetrics(),
+		logger:    log.NewNopLogger(),
+	}
+}
+
+// Init negotiates the protocol with the controller.  It must succeed before
+// any other action is sent.
+func (b *Bridge) Init(ctx context.Context) error {
+	bz, err := json.Marshal(protocol.NewInit())
+	if err != nil {
+		return err
+	}
+	reply, err := b.CallToNode(ctx, string(bz))
+	if err != nil {
+		return err
+	}
+	session, err := protocol.ParseInitReply(reply)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.session = session
+	b.mu.Unlock()
+	return nil
+}
+
+// Session returns what was negotiated with the controller.
+func (b *Bridge) Session() protocol.Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.session
+}
+
+// SetRecorder arranges for all traffic across the bridge to be recorded.
+func (b *Bridge) SetRecorder(recorder *Recorder) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recorder = recorder
+}
+
+// SetMetrics arranges for the bridge and its users to report to metrics.
+func (b *Bridge) SetMetrics(metrics *Metrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.metrics = metrics
+}
+
+// Metrics returns where the bridge and its users report.
+func (b *Bridge) Metrics() *Metrics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.metrics
+}
+
+// SetLogger arranges for every call to be logged with its correlation ID.
+func (b *Bridge) SetLogger(logger log.Logger) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.logger = logger
+}
+
+// SetAbandonHandler arranges for handler to be told the reply port of each
+// call given up on before the controller replied, because its context ended
+// or the watchdog expired it, so that the transport can stop delivering it.
+func (b *Bridge) SetAbandonHandler(handler func(replyPort int)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.abandon = handler
+}
+
+func (b *Bridge) record(rec Record) {
+	b.mu.Lock()
+	recorder := b.recorder
+	b.mu.Unlock()
+	if recorder != nil {
+		recorder.Record(rec)
+	}
+}
+
+// recordGas notes the gas meter that port's storage requests are charged to:
+// its limit, or 0 for none, and what it had consumed before the action began.
+func (b *Bridge) recordGas(port int, limit, consumed uint64) {
+	b.record(Record{Kind: RecordGas, Port: port, GasLimit: limit, GasConsumed: consumed})
+}
+
+// Diagnostics describes the state of a Bridge, for dumps.
+type Diagnostics struct {
+	Session      string `json:"session"`
+	PendingCalls int    `json:"pendingCalls"`
+	OpenPorts    int    `json:"openPorts"`
+	Halted       string `json:"halted,omitempty"`
+}
+
+// Diagnostics returns a snapshot of the bridge's state.
+func (b *Bridge) Diagnostics() Diagnostics {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Diagnostics{
+		Session:      b.session.String(),
+		PendingCalls: len(b.replies),
+		OpenPorts:    len(b.ports),
+		Halted:       ErrorString(b.halted),
+	}
+}
+
+// SendToNode sends a message to the controller without waiting for a reply.
+func (b *Bridge) SendToNode(str string) error {
+	if err := b.Halted(); err != nil {
+		return err
+	}
+	b.record(Record{Kind: RecordSend, Body: str})
+	return b.transport(0, str)
+}
+
+// CallToNode sends a message to the controller and blocks until it replies,
+// ctx is done, or the watchdog gives up on the controller.
+func (b *Bridge) CallToNode(ctx context.Context, str string) (string, error) {
+	b.mu.Lock()
+	if b.halted != nil {
+		b.mu.Unlock()
+		return "", b.halted
+	}
+	b.lastReply++
+	replyPort := b.lastReply
+	call := &outstandingCall{
+		ch:      make(chan bridgeReply, 1),
+		started: time.Now(),
+	}
+	b.replies[replyPort] = call
+	metrics, logger := b.metrics, b.logger
+	b.mu.Unlock()
+
+	b.record(Record{Kind: RecordCall, ReplyPort: replyPort, Body: str})
+
+	// Malformed actions are still sent, for the controller to reject.
+	action, _ := protocol.ParseActionHeader(str)
+	logger = logger.With("type", action.Type, "correlationId", action.CorrelationID)
+	logger.Debug("calling controller")
+
+	defer func() {
+		b.mu.Lock()
+		_, abandoned := b.replies[replyPort]
+		delete(b.replies, replyPort)
+		abandon := b.abandon
+		b.mu.Unlock()
+		if abandoned && abandon != nil {
+			abandon(replyPort)
+		}
+	}()
+
+	ret, err := b.awaitCall(ctx, replyPort, call, str)
+	elapsed := time.Since(call.started)
+	metrics.CallSeconds.With("action_type", action.Type).Observe(elapsed.Seconds())
+	if err != nil {
+		logger.Error("controller call failed", "elapsed", elapsed, "err", err)
+	} else {
+		logger.Debug("controller answered", "elapsed", elapsed)
+	}
+	return ret, err
+}
+
+func (b *Bridge) awaitCall(ctx context.Context, replyPort int, call *outstandingCall, str string) (string, error) {
+	if err := b.transport(replyPort, str); err != nil {
+		return "", err
+	}
+
+	select {
+	case ret := <-call.ch:
+		return ret.str, ret.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Reply wakes up the call waiting on replyPort.
+func (b *Bridge) Reply(replyPort int, str string, err error) {
+	b.record(Record{Kind: RecordReply, ReplyPort: replyPort, Body: str, Error: ErrorString(err)})
+	b.mu.Lock()
+	call := b.replies[replyPort]
+	delete(b.replies, replyPort)
+	b.mu.Unlock()
+	if call == nil {
+		// Unexpected reply.
+		// This is okay, since the caller decides whether or
+		// not she wants to listen for replies.
+		return
+	}
+	call.ch <- bridgeReply{str: str, err: err}
+}
+
+// RegisterPortHandler allocates a port on which the controller can reach
+// portHandler.
+func (b *Bridge) RegisterPortHandler(portHandler PortHandler) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastPort++
+	b.ports[b.lastPort] = portHandler
+	return b.lastPort
+}
+
+func (b *Bridge) UnregisterPortHandler(portNum int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.ports, portNum)
+}
+
+// ReceiveFromNode dispatches a message from the controller to a port.
+func (b *Bridge) ReceiveFromNode(portNum int, msg string) (string, error) {
+	b.mu.Lock()
+	handler := b.ports[portNum]
+	b.mu.Unlock()
+	b.record(Record{Kind: RecordReceive, Port: portNum, Body: msg})
+	var ret string
+	var err error
+	if handler == nil {
+		err = errors.New("Unregistered port " + fmt.Sprintf("%d", portNum))
+	} else {
+		ret, err = handler.Receive(msg)
+	}
+	b.record(Record{Kind: RecordReceiveReply, Port: portNum, Body: ret, Error: ErrorString(err)})
+	return ret, err
+}
+
+// StartWatchdog fails every call that has been waiting longer than timeout,
+// stops accepting new ones, and calls halt once, so that consensus doesn't
+// hang forever on a controller that went away.
+func (b *Bridge) StartWatchdog(timeout time.Duration, halt func(error)) {
+	if timeout <= 0 {
+		return
+	}
+	interval := timeout / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := b.expireCalls(timeout); err != nil {
+				halt(err)
+				return
+			}
+		}
+	}()
+}
+
+func (b *Bridge) expireCalls(timeout time.Duration) error {
+	b.mu.Lock()
+	var expired []int
+	now := time.Now()
+	for replyPort, call := range b.replies {
+		if now.Sub(call.started) < timeout {
+			continue
+		}
+		b.halted = fmt.Errorf("%s within %s", ErrControllerStalled, timeout)
+		call.ch <- bridgeReply{err: b.halted}
+		delete(b.replies, replyPort)
+		expired = append(expired, replyPort)
+	}
+	if b.halted != nil {
+		for replyPort, call := range b.replies {
+			call.ch <- bridgeReply{err: b.halted}
+			delete(b.replies, replyPort)
+			expired = append(expired, replyPort)
+		}
+	}
+	halted, abandon := b.halted, b.abandon
+	b.mu.Unlock()
+
+	if abandon != nil {
+		for _, replyPort := range expired {
+			abandon(replyPort)
+		}
+	}
+	return halted
+}
+
+// Halt stops the bridge accepting calls, because the controller can no longer
+// be kept in step with consensus.  Only the first error is kept.
+func (b *Bridge) Halt(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.halted == nil {
+		b.halted = err
+	}
+}
+
+// Halted returns why the bridge stopped accepting calls, if it has.
+func (b *Bridge) Halted() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.halted
+}
@@ -0,0 +1,120 @@
+package swingset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// newAnsweringBridge returns a Bridge to a controller that answers every call
+// with reply.
+func newAnsweringBridge(reply string) *Bridge {
+	var b *Bridge
+	b = NewBridge(func(replyPort int, str string) error {
+		b.Reply(replyPort, reply, nil)
+		return nil
+	})
+	return b
+}
+
+func TestBridgeInitNegotiates(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		batch bool
+		err   bool
+	}{
+		{fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["batch"]}`, protocol.Version), true, false},
+		{fmt.Sprintf(`{"protocolVersion":%d,"capabilities":[]}`, protocol.Version), false, false},
+		{fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["batch"]}`, protocol.Version-1), false, true},
+		{fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["batch"]}`, protocol.Version+1), false, true},
+		{`true`, false, true},
+	} {
+		b := newAnsweringBridge(tc.reply)
+		err := b.Init(context.Background())
+		if (err != nil) != tc.err {
+			t.Errorf("Init answered with %s: error = %v, want error %t", tc.reply, err, tc.err)
+			continue
+		}
+		if tc.err {
+			if b.Session().ProtocolVersion != 0 {
+				t.Errorf("Init answered with %s kept session %s", tc.reply, b.Session())
+			}
+			continue
+		}
+		if got := b.Session().Has(protocol.CapabilityBatch); got != tc.batch {
+			t.Errorf("Init answered with %s: batch negotiated = %t, want %t", tc.reply, got, tc.batch)
+		}
+	}
+}
+
+func TestWatchdogExpiresCalls(t *testing.T) {
+	// A controller that takes every call and never answers
+	sent := make(chan int, 1)
+	b := NewBridge(func(replyPort int, str string) error {
+		sent <- replyPort
+		return nil
+	})
+	abandoned := make(chan int, 1)
+	b.SetAbandonHandler(func(replyPort int) {
+		abandoned <- replyPort
+	})
+
+	type result struct {
+		ret string
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		ret, err := b.CallToNode(context.Background(), `{"type":"BEGIN_BLOCK"}`)
+		results <- result{ret, err}
+	}()
+	replyPort := <-sent
+
+	// A call younger than the timeout is left alone.
+	if err := b.expireCalls(time.Hour); err != nil {
+		t.Fatalf("expired a call before its time: %s", err)
+	}
+	if pending := b.Diagnostics().PendingCalls; pending != 1 {
+		t.Fatalf("%d calls pending, want 1", pending)
+	}
+
+	halts := make(chan error, 1)
+	b.StartWatchdog(10*time.Millisecond, func(err error) {
+		halts <- err
+	})
+	var res result
+	select {
+	case res = <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog didn't expire the call")
+	}
+	if res.err == nil || !strings.Contains(res.err.Error(), ErrControllerStalled.Error()) {
+		t.Errorf("expired call = %q, %v, want %s", res.ret, res.err, ErrControllerStalled)
+	}
+	if err := <-halts; err == nil || b.Halted() == nil {
+		t.Errorf("watchdog halted with %v, and the bridge with %v", err, b.Halted())
+	}
+
+	// The reply port is freed, and the transport told to forget it.
+	if pending := b.Diagnostics().PendingCalls; pending != 0 {
+		t.Errorf("%d calls pending after the watchdog expired them", pending)
+	}
+	select {
+	case port := <-abandoned:
+		if port != replyPort {
+			t.Errorf("abandoned reply port %d, want %d", port, replyPort)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expired call wasn't abandoned")
+	}
+	// A late reply goes nowhere.
+	b.Reply(replyPort, "true", nil)
+
+	if _, err := b.CallToNode(context.Background(), `{"type":"BEGIN_BLOCK"}`); err == nil {
+		t.Error("halted bridge made a call")
+	}
+}
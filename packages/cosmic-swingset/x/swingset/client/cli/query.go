@@ -1,14 +1,27 @@
 package cli
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"os"
 
+	"github.com/Agoric/cosmic-swingset/x/swingset/client/utils"
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
 	"github.com/cosmos/cosmos-sdk/client"
 	"github.com/cosmos/cosmos-sdk/client/context"
 	"github.com/cosmos/cosmos-sdk/codec"
-	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	FlagStart = "start"
+	FlagLimit = "limit"
+	FlagProve = "prove"
+	FlagDepth = "depth"
+
+	FlagAppHash = "app-hash"
 )
 
 func GetQueryCmd(storeKey string, cdc *codec.Codec) *cobra.Command {
@@ -22,15 +35,23 @@ func GetQueryCmd(storeKey string, cdc *codec.Codec) *cobra.Command {
 	swingsetQueryCmd.AddCommand(client.GetCommands(
 		GetCmdGetStorage(storeKey, cdc),
 		GetCmdGetKeys(storeKey, cdc),
+		GetCmdHas(storeKey, cdc),
+		GetCmdEntries(storeKey, cdc),
+		GetCmdValues(storeKey, cdc),
+		GetCmdSize(storeKey, cdc),
+		GetCmdTree(storeKey, cdc),
 		GetCmdMailbox(storeKey, cdc),
+		GetCmdPeers(storeKey, cdc),
+		GetCmdStatus(storeKey, cdc),
+		GetCmdUsage(storeKey, cdc),
+		GetCmdVerify(storeKey, cdc),
 	)...)
 	return swingsetQueryCmd
 }
 
-
 // GetCmdGetStorage queries information about storage
 func GetCmdGetStorage(queryRoute string, cdc *codec.Codec) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "storage [path]",
 		Short: "get storage for path",
 		Args:  cobra.ExactArgs(1),
@@ -38,7 +59,11 @@ func GetCmdGetStorage(queryRoute string, cdc *codec.Codec) *cobra.Command {
 			cliCtx := context.NewCLIContext().WithCodec(cdc)
 			path := args[0]
 
-			res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/storage/%s", queryRoute, path), nil)
+			if viper.GetBool(FlagProve) {
+				return printProvenStorage(cliCtx, queryRoute, path)
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/storage/%s", queryRoute, path), nil)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "could not find storage path - %s: %s\n", path, err)
 				return nil
@@ -46,17 +71,22 @@ func GetCmdGetStorage(queryRoute string, cdc *codec.Codec) *cobra.Command {
 
 			var out types.QueryResStorage
 			cdc.MustUnmarshalJSON(res, &out)
+			out.Height = height
 			return cliCtx.PrintOutput(out)
 		},
 	}
+	cmd.Flags().Bool(FlagProve, false, "Get a proof of the value, and check it unless --trust-node")
+	return cmd
 }
 
 // GetCmdGetKeys queries storage keys
 func GetCmdGetKeys(queryRoute string, cdc *codec.Codec) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "keys [path]",
 		Short: "get storage subkeys for path",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Get a page of the storage subkeys for path, in order, and report the key
+to pass as --start to get the next page.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cliCtx := context.NewCLIContext().WithCodec(cdc)
 			var path string
@@ -64,7 +94,13 @@ func GetCmdGetKeys(queryRoute string, cdc *codec.Codec) *cobra.Command {
 				path = args[0]
 			}
 
-			res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/keys/%s", queryRoute, path), nil)
+			params := types.NewQueryKeysParams(viper.GetString(FlagStart), viper.GetInt(FlagLimit))
+			bz, err := cdc.MarshalJSON(params)
+			if err != nil {
+				return err
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/keys/%s", queryRoute, path), bz)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "could not find keys path - %s: %s\n", path, err)
 				return nil
@@ -72,14 +108,196 @@ func GetCmdGetKeys(queryRoute string, cdc *codec.Codec) *cobra.Command {
 
 			var out types.QueryResKeys
 			cdc.MustUnmarshalJSON(res, &out)
+			if out.Keys == nil {
+				out.Keys = []string{}
+			}
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+	cmd.Flags().String(FlagStart, "", "First key to get")
+	cmd.Flags().Int(FlagLimit, types.DefaultPageLimit, fmt.Sprintf("Maximum number of keys to get (at most %d)", types.MaxPageLimit))
+	return cmd
+}
+
+// GetCmdHas queries whether a path has a value
+func GetCmdHas(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "has [path]",
+		Short: "say whether path has a value, even an empty one",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			path := args[0]
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/has/%s", queryRoute, path), nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not check storage path - %s: %s\n", path, err)
+				return nil
+			}
+
+			var out types.QueryResHas
+			cdc.MustUnmarshalJSON(res, &out)
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+}
+
+// GetCmdEntries queries storage subkeys with their values
+func GetCmdEntries(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "entries [path]",
+		Short: "get storage subkeys for path, with their values",
+		Long: `Get the storage subkeys for path, with their values, or the top-level
+entries if no path is given.  A subkey that only has subkeys of its own has an
+empty value.  Entries come a page at a time; use --start with the returned
+next key to get the next page.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			params := types.NewQueryKeysParams(viper.GetString(FlagStart), viper.GetInt(FlagLimit))
+			bz, err := cdc.MarshalJSON(params)
+			if err != nil {
+				return err
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/entries/%s", queryRoute, path), bz)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not find entries path - %s: %s\n", path, err)
+				return nil
+			}
+
+			var out types.QueryResEntries
+			cdc.MustUnmarshalJSON(res, &out)
+			if out.Entries == nil {
+				out.Entries = []types.KeyValue{}
+			}
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+	cmd.Flags().String(FlagStart, "", "First key to get")
+	cmd.Flags().Int(FlagLimit, types.DefaultPageLimit, fmt.Sprintf("Maximum number of entries to get (at most %d)", types.MaxPageLimit))
+	return cmd
+}
+
+// GetCmdValues queries the values of storage subkeys
+func GetCmdValues(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "values [path]",
+		Short: "get the values of the storage subkeys for path",
+		Long: `Get the values of the storage subkeys for path, in the order of their
+keys, or of the top-level keys if no path is given.  Values come a page at a
+time; use --start with the returned next key to get the next page.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			params := types.NewQueryKeysParams(viper.GetString(FlagStart), viper.GetInt(FlagLimit))
+			bz, err := cdc.MarshalJSON(params)
+			if err != nil {
+				return err
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/values/%s", queryRoute, path), bz)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not find values path - %s: %s\n", path, err)
+				return nil
+			}
+
+			var out types.QueryResValues
+			cdc.MustUnmarshalJSON(res, &out)
+			if out.Values == nil {
+				out.Values = []*string{}
+			}
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+	cmd.Flags().String(FlagStart, "", "Key of the first value to get")
+	cmd.Flags().Int(FlagLimit, types.DefaultPageLimit, fmt.Sprintf("Maximum number of values to get (at most %d)", types.MaxPageLimit))
+	return cmd
+}
+
+// GetCmdSize queries how many subkeys a path has
+func GetCmdSize(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "size [path]",
+		Short: "get the number of storage subkeys for path, or of top-level keys",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/size/%s", queryRoute, path), nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not get size of path - %s: %s\n", path, err)
+				return nil
+			}
+
+			var out types.QueryResSize
+			cdc.MustUnmarshalJSON(res, &out)
+			out.Height = height
 			return cliCtx.PrintOutput(out)
 		},
 	}
 }
 
+// GetCmdTree queries a storage subtree
+func GetCmdTree(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tree [path]",
+		Short: "get the storage subtree at path, down to --depth levels of subkeys",
+		Long: `Get the value at path and the subtrees of its subkeys, down to --depth
+levels of subkeys, or the top-level subtrees if no path is given.  The whole
+tree is read at one height, and has at most 1000 nodes.  A subtree whose
+subkeys were too deep or too many to include is marked truncated.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			params := types.NewQueryTreeParams(viper.GetInt(FlagDepth))
+			bz, err := cdc.MarshalJSON(params)
+			if err != nil {
+				return err
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/tree/%s", queryRoute, path), bz)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not get tree path - %s: %s\n", path, err)
+				return nil
+			}
+
+			var out types.QueryResTree
+			cdc.MustUnmarshalJSON(res, &out)
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+	cmd.Flags().Int(FlagDepth, 1, "Levels of subkeys to include")
+	return cmd
+}
+
 // GetCmdMailbox queries information about a mailbox
 func GetCmdMailbox(queryRoute string, cdc *codec.Codec) *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "mailbox [peer]",
 		Short: "get mailbox for peer",
 		Args:  cobra.ExactArgs(1),
@@ -87,15 +305,160 @@ func GetCmdMailbox(queryRoute string, cdc *codec.Codec) *cobra.Command {
 			cliCtx := context.NewCLIContext().WithCodec(cdc)
 			peer := args[0]
 
-			res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/mailbox/%s", queryRoute, peer), nil)
+			if viper.GetBool(FlagProve) {
+				return printProvenStorage(cliCtx, queryRoute, "mailbox."+peer)
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/mailbox/%s", queryRoute, peer), nil)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "could not find peer mailbox - %s: %s\n", peer, err)
 				return nil
 			}
 
-			var out types.QueryResStorage
+			var out types.QueryResMailbox
+			cdc.MustUnmarshalJSON(res, &out)
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+	cmd.Flags().Bool(FlagProve, false, "Get a proof of the mailbox, and check it unless --trust-node")
+	return cmd
+}
+
+// GetCmdPeers lists the peers with mailboxes
+func GetCmdPeers(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peers",
+		Short: "list peers with their outbox length and last ack",
+		Long: `List the peers with mailboxes, in order, with how many messages are
+waiting in each outbox and the last inbound message acknowledged.
+
+Peers come a page at a time; use --start with the reported next peer to get
+the next page.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+
+			params := types.NewQueryPeersParams(viper.GetString(FlagStart), viper.GetInt(FlagLimit))
+			bz, err := cdc.MarshalJSON(params)
+			if err != nil {
+				return err
+			}
+
+			res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/peers", queryRoute), bz)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not get peers: %s\n", err)
+				return nil
+			}
+
+			var out types.QueryResPeers
+			cdc.MustUnmarshalJSON(res, &out)
+			out.Height = height
+			return cliCtx.PrintOutput(out)
+		},
+	}
+	cmd.Flags().String(FlagStart, "", "First peer to get")
+	cmd.Flags().Int(FlagLimit, types.DefaultPageLimit, fmt.Sprintf("Maximum number of peers to get (at most %d)", types.MaxPageLimit))
+	return cmd
+}
+
+func printProvenStorage(cliCtx context.CLIContext, storeName, path string) error {
+	out, err := utils.QueryProvenStorage(cliCtx, storeName, path)
+	if err != nil {
+		return err
+	}
+	return cliCtx.PrintOutput(out)
+}
+
+// GetCmdStatus queries the kernel status and failure policy
+func GetCmdStatus(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "get kernel status and BEGIN_BLOCK failure policy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+
+			res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/status", queryRoute), nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not get status: %s\n", err)
+				return nil
+			}
+
+			var out types.QueryResStatus
+			cdc.MustUnmarshalJSON(res, &out)
+			return cliCtx.PrintOutput(out)
+		},
+	}
+}
+
+// GetCmdUsage queries storage usage and quotas
+func GetCmdUsage(queryRoute string, cdc *codec.Codec) *cobra.Command {
+	return &cobra.Command{
+		Use:   "usage [namespace]",
+		Short: "get storage usage and quotas, for one top-level path segment or all",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+			var namespace string
+			if len(args) > 0 {
+				namespace = args[0]
+			}
+
+			res, _, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/usage/%s", queryRoute, namespace), nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not get usage: %s\n", err)
+				return nil
+			}
+
+			var out types.QueryResUsage
 			cdc.MustUnmarshalJSON(res, &out)
 			return cliCtx.PrintOutput(out)
 		},
 	}
 }
+
+// GetCmdVerify checks a proof from a storage or mailbox query
+func GetCmdVerify(storeName string, cdc *codec.Codec) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [proof-file]",
+		Short: "verify the output of a storage or mailbox query with --prove",
+		Long: `Verify the output of a storage or mailbox query made with --prove -o json,
+such as one a client saved to check its mailbox contents.
+
+The proof is checked against --app-hash, or if that is not given, against a
+header trusted by the light client verifier, which needs --trust-node=false
+and --chain-id.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx := context.NewCLIContext().WithCodec(cdc)
+
+			bz, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var ps utils.ProvenStorage
+			if err := cdc.UnmarshalJSON(bz, &ps); err != nil {
+				return err
+			}
+
+			var appHash []byte
+			if appHashStr := viper.GetString(FlagAppHash); appHashStr != "" {
+				appHash, err = hex.DecodeString(appHashStr)
+			} else {
+				appHash, err = utils.TrustedAppHash(cliCtx, ps.Height)
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := utils.VerifyStorage(cdc, storeName, ps, appHash); err != nil {
+				return err
+			}
+			fmt.Printf("verified %s at height %d\n", ps.Path, ps.Height)
+			return nil
+		},
+	}
+	cmd.Flags().String(FlagAppHash, "", "Hex app hash of the state at the proof's height, from a header you trust")
+	return cmd
+}
@@ -3,7 +3,10 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/Agoric/cosmic-swingset/x/swingset/client/utils"
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
 	"github.com/cosmos/cosmos-sdk/client/context"
 
 	"github.com/cosmos/cosmos-sdk/types/rest"
@@ -13,51 +16,231 @@ import (
 
 func getStorageHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
 		vars := mux.Vars(r)
 		paramType := vars[pathName]
 
-		res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/storage/%s", storeName, paramType), nil)
+		if r.URL.Query().Get("prove") == "true" {
+			writeProvenStorage(w, cliCtx, storeName, paramType)
+			return
+		}
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/storage/%s", storeName, paramType), nil)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		cliCtx = cliCtx.WithHeight(height)
+
+		rest.PostProcessResponse(w, cliCtx, res)
+	}
+}
+
+// parsePage reads the start and limit of the page a request asks for.
+func parsePage(r *http.Request) (start string, limit int, err error) {
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid limit: %s", err)
+		}
+	}
+	return r.URL.Query().Get("start"), limit, nil
+}
+
+// getPathHandler answers a query about a storage path that takes no
+// parameters, such as has or size.
+func getPathHandler(cliCtx context.CLIContext, storeName, query string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
+		vars := mux.Vars(r)
+		path := vars[pathName]
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/%s/%s", storeName, query, path), nil)
 		if err != nil {
 			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
 			return
 		}
 
+		cliCtx = cliCtx.WithHeight(height)
 		rest.PostProcessResponse(w, cliCtx, res)
 	}
 }
 
-func getKeysHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
+// getPageHandler answers a query for a page of the subkeys of a storage
+// path, such as keys, entries or values.
+func getPageHandler(cliCtx context.CLIContext, storeName, query string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
 		vars := mux.Vars(r)
-		paramType, ok := vars[keysName]
+		path := vars[pathName]
 
-		var query string
-		if ok {
-			query = fmt.Sprintf("custom/%s/keys/%s", storeName, paramType)
-		} else {
-			query = fmt.Sprintf("custom/%s/keys/", storeName)
+		start, limit, err := parsePage(r)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params := types.NewQueryKeysParams(start, limit)
+		bz, err := cliCtx.Codec.MarshalJSON(params)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		res, _, err := cliCtx.QueryWithData(query, nil)
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/%s/%s", storeName, query, path), bz)
 		if err != nil {
 			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
 			return
 		}
 
+		cliCtx = cliCtx.WithHeight(height)
+		rest.PostProcessResponse(w, cliCtx, res)
+	}
+}
+
+func getTreeHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
+		vars := mux.Vars(r)
+		path := vars[pathName]
+
+		var bz []byte
+		if depthStr := r.URL.Query().Get("depth"); depthStr != "" {
+			depth, err := strconv.Atoi(depthStr)
+			if err != nil {
+				rest.WriteErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid depth: %s", err))
+				return
+			}
+			bz, err = cliCtx.Codec.MarshalJSON(types.NewQueryTreeParams(depth))
+			if err != nil {
+				rest.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/tree/%s", storeName, path), bz)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		cliCtx = cliCtx.WithHeight(height)
 		rest.PostProcessResponse(w, cliCtx, res)
 	}
 }
 
 func getMailboxHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
 		vars := mux.Vars(r)
 		paramType := vars[peerName]
 
-		res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/mailbox/%s", storeName, paramType), nil)
+		if r.URL.Query().Get("prove") == "true" {
+			writeProvenStorage(w, cliCtx, storeName, "mailbox."+paramType)
+			return
+		}
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/mailbox/%s", storeName, paramType), nil)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		cliCtx = cliCtx.WithHeight(height)
+
+		rest.PostProcessResponse(w, cliCtx, res)
+	}
+}
+
+func getPeersHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
+		start, limit, err := parsePage(r)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params := types.NewQueryPeersParams(start, limit)
+		bz, err := cliCtx.Codec.MarshalJSON(params)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/peers", storeName), bz)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		cliCtx = cliCtx.WithHeight(height)
+		rest.PostProcessResponse(w, cliCtx, res)
+	}
+}
+
+// writeProvenStorage answers with the value at path and its proof.
+func writeProvenStorage(w http.ResponseWriter, cliCtx context.CLIContext, storeName, path string) {
+	ps, err := utils.QueryProvenStorage(cliCtx, storeName, path)
+	if err != nil {
+		rest.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rest.PostProcessResponse(w, cliCtx.WithHeight(ps.Height), ps)
+}
+
+func getStatusHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, _, err := cliCtx.QueryWithData(fmt.Sprintf("custom/%s/status", storeName), nil)
+		if err != nil {
+			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		rest.PostProcessResponse(w, cliCtx, res)
+	}
+}
+
+func getUsageHandler(cliCtx context.CLIContext, storeName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cliCtx, ok := rest.ParseQueryHeightOrReturnBadRequest(w, cliCtx, r)
+		if !ok {
+			return
+		}
+
+		vars := mux.Vars(r)
+		namespace := vars[namespaceName]
+
+		res, height, err := utils.QueryWithData(cliCtx, fmt.Sprintf("custom/%s/usage/%s", storeName, namespace), nil)
 		if err != nil {
 			rest.WriteErrorResponse(w, http.StatusNotFound, err.Error())
 			return
 		}
 
+		cliCtx = cliCtx.WithHeight(height)
 		rest.PostProcessResponse(w, cliCtx, res)
 	}
 }
@@ -10,15 +10,29 @@ import (
 
 const (
 	pathName = "storage"
-	keysName = "keys"
 	peerName = "peer"
+
+	namespaceName = "namespace"
 )
 
 // RegisterRoutes - Central function to define routes that get registered by the main application
 func RegisterRoutes(cliCtx context.CLIContext, r *mux.Router, storeName string) {
 	r.HandleFunc(fmt.Sprintf("/%s/mailbox/{%s}", storeName, peerName), getMailboxHandler(cliCtx, storeName)).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/mailbox", storeName), deliverMailboxHandler(cliCtx)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/%s/peers", storeName), getPeersHandler(cliCtx, storeName)).Methods("GET")
 	r.HandleFunc(fmt.Sprintf("/%s/storage/{%s}", storeName, pathName), getStorageHandler(cliCtx, storeName)).Methods("GET")
-	r.HandleFunc(fmt.Sprintf("/%s/keys/{%s}", storeName, keysName), getKeysHandler(cliCtx, keysName)).Methods("GET")
-	r.HandleFunc(fmt.Sprintf("/%s/keys", storeName), getKeysHandler(cliCtx, keysName)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/keys/{%s}", storeName, pathName), getPageHandler(cliCtx, storeName, "keys")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/keys", storeName), getPageHandler(cliCtx, storeName, "keys")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/has/{%s}", storeName, pathName), getPathHandler(cliCtx, storeName, "has")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/entries/{%s}", storeName, pathName), getPageHandler(cliCtx, storeName, "entries")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/entries", storeName), getPageHandler(cliCtx, storeName, "entries")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/values/{%s}", storeName, pathName), getPageHandler(cliCtx, storeName, "values")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/values", storeName), getPageHandler(cliCtx, storeName, "values")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/size/{%s}", storeName, pathName), getPathHandler(cliCtx, storeName, "size")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/size", storeName), getPathHandler(cliCtx, storeName, "size")).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/tree/{%s}", storeName, pathName), getTreeHandler(cliCtx, storeName)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/tree", storeName), getTreeHandler(cliCtx, storeName)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/status", storeName), getStatusHandler(cliCtx, storeName)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/usage/{%s}", storeName, namespaceName), getUsageHandler(cliCtx, storeName)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/usage", storeName), getUsageHandler(cliCtx, storeName)).Methods("GET")
 }
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+)
+
+// What a node says when it doesn't have the state at the height asked for.
+const errVersionDoesNotExist = "version does not exist"
+
+// QueryWithData is cliCtx.QueryWithData, but says clearly when the node
+// doesn't have the state at the height asked for.
+func QueryWithData(cliCtx context.CLIContext, path string, data []byte) ([]byte, int64, error) {
+	res, height, err := cliCtx.QueryWithData(path, data)
+	if err != nil {
+		return nil, 0, HeightError(cliCtx.Height, err)
+	}
+	return res, height, nil
+}
+
+// HeightError explains err if it is because the state at height is gone.
+func HeightError(height int64, err error) error {
+	if height > 0 && strings.Contains(err.Error(), errVersionDoesNotExist) {
+		return fmt.Errorf("the node has no state at height %d; it was pruned or is not committed yet: %s",
+			height, err)
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store/rootmulti"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/keeper"
+)
+
+// ProvenStorage is the value at a storage path, with a proof that the chain
+// held it at Height, or that it held nothing there unless Exists.
+type ProvenStorage struct {
+	Path   string        `json:"path"`
+	Exists bool          `json:"exists"`
+	Value  string        `json:"value"`
+	Height int64         `json:"height"`
+	Proof  *merkle.Proof `json:"proof"`
+}
+
+func (ps ProvenStorage) String() string {
+	if !ps.Exists {
+		return fmt.Sprintf("%s at height %d: absent", ps.Path, ps.Height)
+	}
+	return fmt.Sprintf("%s at height %d: %s", ps.Path, ps.Height, ps.Value)
+}
+
+// QueryProvenStorage gets the value at path with its proof.  Unless the CLI
+// context trusts the node, the proof is checked against a header from the
+// context's verifier.
+func QueryProvenStorage(cliCtx context.CLIContext, storeName, path string) (ProvenStorage, error) {
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return ProvenStorage{}, err
+	}
+
+	opts := rpcclient.ABCIQueryOptions{Height: cliCtx.Height, Prove: true}
+	result, err := node.ABCIQueryWithOptions(fmt.Sprintf("/store/%s/key", storeName), keeper.StorageKey(path), opts)
+	if err != nil {
+		return ProvenStorage{}, err
+	}
+	resp := result.Response
+	if !resp.IsOK() || (resp.Proof == nil && resp.Log != "") {
+		return ProvenStorage{}, HeightError(cliCtx.Height, errors.New(resp.Log))
+	}
+
+	ps := ProvenStorage{Path: path, Height: resp.Height, Proof: resp.Proof}
+	if resp.Value != nil {
+		value, err := keeper.UnmarshalValue(cliCtx.Codec, path, resp.Value)
+		if err != nil {
+			return ProvenStorage{}, err
+		}
+		ps.Exists = true
+		ps.Value = value
+	}
+
+	if !cliCtx.TrustNode {
+		appHash, err := TrustedAppHash(cliCtx, ps.Height)
+		if err != nil {
+			return ProvenStorage{}, err
+		}
+		if err := VerifyStorage(cliCtx.Codec, storeName, ps, appHash); err != nil {
+			return ProvenStorage{}, err
+		}
+	}
+	return ps, nil
+}
+
+// TrustedAppHash returns the app hash of the state at height, taken from a
+// header that the CLI context's light client verifier trusts.
+func TrustedAppHash(cliCtx context.CLIContext, height int64) ([]byte, error) {
+	if cliCtx.Verifier == nil {
+		return nil, errors.New("no light client verifier to trust headers; use --trust-node=false and --chain-id")
+	}
+	node, err := cliCtx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	// The app hash for height H is in header H+1.
+	if err := rpcclient.WaitForHeight(node, height+1, nil); err != nil {
+		return nil, err
+	}
+	commit, err := cliCtx.Verify(height + 1)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Header.AppHash, nil
+}
+
+// VerifyStorage checks the proof of ps against appHash, the app hash of the
+// state at ps.Height.
+func VerifyStorage(cdc *codec.Codec, storeName string, ps ProvenStorage, appHash []byte) error {
+	if ps.Proof == nil {
+		return fmt.Errorf("no proof for %s", ps.Path)
+	}
+
+	keyPath := merkle.KeyPath{}.
+		AppendKey([]byte(storeName), merkle.KeyEncodingURL).
+		AppendKey(keeper.StorageKey(ps.Path), merkle.KeyEncodingURL)
+
+	prt := rootmulti.DefaultProofRuntime()
+	var err error
+	if !ps.Exists {
+		err = prt.VerifyAbsence(ps.Proof, appHash, keyPath.String())
+	} else {
+		// The value is proven if the state held it in any encoding.
+		var encodings [][]byte
+		encodings, err = keeper.ValueEncodings(cdc, ps.Path, ps.Value)
+		for _, value := range encodings {
+			if err = prt.VerifyValue(ps.Proof, appHash, keyPath.String(), value); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot verify %s at height %d: %s", ps.Path, ps.Height, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/client/context"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/params"
+	abci "github.com/tendermint/tendermint/abci/types"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	"github.com/tendermint/tendermint/libs/log"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/keeper"
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// storeNode answers ABCI queries from a committed multistore, as a node's
+// app would.
+type storeNode struct {
+	rpcclient.Client
+	ms sdk.CommitMultiStore
+}
+
+func (n storeNode) ABCIQueryWithOptions(path string, data cmn.HexBytes, opts rpcclient.ABCIQueryOptions) (*ctypes.ResultABCIQuery, error) {
+	res := n.ms.(sdk.Queryable).Query(abci.RequestQuery{
+		Path:   strings.TrimPrefix(path, "/store"),
+		Data:   data,
+		Height: opts.Height,
+		Prove:  opts.Prove,
+	})
+	return &ctypes.ResultABCIQuery{Response: res}, nil
+}
+
+// newProvingNode commits entries to an in-memory multistore, and returns a
+// CLI context that trusts a node serving it, and the app hash it committed.
+func newProvingNode(t *testing.T, entries map[string]string) (context.CLIContext, []byte) {
+	t.Helper()
+	key := sdk.NewKVStoreKey(types.StoreKey)
+	paramsKey := sdk.NewKVStoreKey(params.StoreKey)
+	paramsTKey := sdk.NewTransientStoreKey(params.TStoreKey)
+
+	// Each store gets its own prefix of db, as committed versions need.
+	ms := store.NewCommitMultiStore(dbm.NewMemDB())
+	ms.MountStoreWithDB(key, sdk.StoreTypeIAVL, nil)
+	ms.MountStoreWithDB(paramsKey, sdk.StoreTypeIAVL, nil)
+	ms.MountStoreWithDB(paramsTKey, sdk.StoreTypeTransient, nil)
+	if err := ms.LoadLatestVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	cdc := codec.New()
+	types.RegisterCodec(cdc)
+	paramsKeeper := params.NewKeeper(cdc, paramsKey, paramsTKey, params.DefaultCodespace)
+	k := keeper.NewKeeper(nil, key, paramsKeeper.Subspace(types.DefaultParamspace), cdc, types.DefaultCodespace)
+	ctx := sdk.NewContext(ms, abci.Header{Height: 1}, false, log.NewNopLogger())
+	k.SetParams(ctx, types.DefaultParams())
+	k.SetStoreVersion(ctx, keeper.StoreVersion)
+	for path, value := range entries {
+		if err := k.SetStorage(ctx, path, types.Storage{Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitID := ms.Commit()
+
+	cliCtx := context.CLIContext{}.
+		WithCodec(cdc).
+		WithClient(storeNode{ms: ms}).
+		WithTrustNode(true).
+		WithHeight(commitID.Version)
+	return cliCtx, commitID.Hash
+}
+
+func TestVerifyStorage(t *testing.T) {
+	mailbox := types.Mailbox{Ack: 2, Outbox: []types.OutboxMessage{{Num: 1, Body: "hi"}}}
+	forged := types.Mailbox{Ack: 3, Outbox: mailbox.Outbox}
+	cliCtx, appHash := newProvingNode(t, map[string]string{
+		"data.a":       "hello",
+		"mailbox.peer": mailbox.KernelValue(),
+	})
+
+	for _, tc := range []struct {
+		path     string
+		exists   bool
+		value    string
+		tampered string
+	}{
+		{"data.a", true, "hello", "goodbye"},
+		{"mailbox.peer", true, mailbox.KernelValue(), forged.KernelValue()},
+		{"data.missing", false, "", "conjured"},
+	} {
+		ps, err := QueryProvenStorage(cliCtx, types.StoreKey, tc.path)
+		if err != nil {
+			t.Fatalf("QueryProvenStorage(%s): %s", tc.path, err)
+		}
+		if ps.Exists != tc.exists || ps.Value != tc.value || ps.Height != cliCtx.Height {
+			t.Errorf("QueryProvenStorage(%s) = %s", tc.path, ps)
+		}
+		if err := VerifyStorage(cliCtx.Codec, types.StoreKey, ps, appHash); err != nil {
+			t.Errorf("VerifyStorage(%s): %s", tc.path, err)
+		}
+
+		// Any claim but the proven one is refused.
+		tampered := ps
+		tampered.Exists = true
+		tampered.Value = tc.tampered
+		if err := VerifyStorage(cliCtx.Codec, types.StoreKey, tampered, appHash); err == nil {
+			t.Errorf("verified %s with a tampered value", tc.path)
+		}
+		if tc.exists {
+			absent := ps
+			absent.Exists = false
+			absent.Value = ""
+			if err := VerifyStorage(cliCtx.Codec, types.StoreKey, absent, appHash); err == nil {
+				t.Errorf("verified %s as absent", tc.path)
+			}
+		}
+		if err := VerifyStorage(cliCtx.Codec, types.StoreKey, ps, []byte("not the app hash")); err == nil {
+			t.Errorf("verified %s against the wrong app hash", tc.path)
+		}
+		unproven := ps
+		unproven.Proof = nil
+		if err := VerifyStorage(cliCtx.Codec, types.StoreKey, unproven, appHash); err == nil {
+			t.Errorf("verified %s without a proof", tc.path)
+		}
+	}
+
+	// Without trusting the node, a header is needed to check the proof with.
+	if _, err := QueryProvenStorage(cliCtx.WithTrustNode(false), types.StoreKey, "data.a"); err == nil {
+		t.Error("QueryProvenStorage without a verifier succeeded")
+	}
+}
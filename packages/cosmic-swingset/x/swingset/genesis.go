@@ -1,7 +1,10 @@
 package swingset
 
 import (
-	// "fmt"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	abci "github.com/tendermint/tendermint/abci/types"
@@ -10,31 +13,79 @@ import (
 type GenesisState struct {
 	// TODO: Provisioning records
 	PubKeys []string `json:"swingset_pubkeys"`
+	Params  Params   `json:"params"`
+	// Storage is only here to be refused: see ExportGenesis.
+	Storage []StorageEntry `json:"storage,omitempty"`
 }
 
 func NewGenesisState() GenesisState {
 	return GenesisState{
 		PubKeys: []string{},
+		Params:  DefaultParams(),
 	}
 }
 
+// UnmarshalGenesis decodes a genesis state, giving the parameters it leaves
+// out their defaults, as MigrateStore does for a running chain.  A missing
+// genesis state is the default one.
+func UnmarshalGenesis(bz json.RawMessage) (GenesisState, error) {
+	if len(bz) == 0 {
+		return DefaultGenesisState(), nil
+	}
+	var data GenesisState
+	if err := ModuleCdc.UnmarshalJSON(bz, &data); err != nil {
+		return data, err
+	}
+
+	// Amino can't tell a missing field from a zero one, so look for them.
+	var raw struct {
+		Params map[string]json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(bz, &raw); err != nil {
+		return data, err
+	}
+	defaults := reflect.ValueOf(DefaultParams())
+	params := reflect.ValueOf(&data.Params).Elem()
+	for i := 0; i < params.NumField(); i++ {
+		name := strings.Split(params.Type().Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := raw.Params[name]; !ok {
+			params.Field(i).Set(defaults.Field(i))
+		}
+	}
+	return data, nil
+}
+
 func ValidateGenesis(data GenesisState) error {
-	return nil
+	if len(data.Storage) != 0 {
+		return fmt.Errorf("genesis cannot hold swingset storage; load it into a stopped node with 'store load'")
+	}
+	return data.Params.Validate()
 }
 
 func DefaultGenesisState() GenesisState {
 	return GenesisState{
 		PubKeys: []string{},
+		Params:  DefaultParams(),
 	}
 }
 
 func InitGenesis(ctx sdk.Context, keeper Keeper, data GenesisState) []abci.ValidatorUpdate {
+	keeper.SetParams(ctx, data.Params)
+	keeper.SetStoreVersion(ctx, StoreVersion)
+	if len(data.Storage) != 0 {
+		panic(fmt.Sprintf("cannot init genesis with %d storage entries; load them with 'store load'", len(data.Storage)))
+	}
 	return []abci.ValidatorUpdate{}
 }
 
+// ExportGenesis leaves out storage, since it belongs to the kernel, whose own
+// state isn't exported: a chain restarted from the export starts its kernel
+// afresh, which must find storage empty too.  For the same reason genesis
+// refuses storage rather than importing it.
 func ExportGenesis(ctx sdk.Context, k Keeper) GenesisState {
 	// TODO: Preserve the SwingSet transcript
 	return GenesisState{
 		PubKeys: []string{},
+		Params:  k.GetParams(ctx),
 	}
 }
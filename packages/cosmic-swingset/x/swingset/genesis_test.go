@@ -0,0 +1,44 @@
+package swingset
+
+import (
+	"testing"
+)
+
+func TestGenesisRefusesStorage(t *testing.T) {
+	ctx, k := newTestKeeper(t)
+	if err := k.SetStorage(ctx, "data.a", Storage{Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// What ExportGenesis writes, InitGenesis takes.
+	exported := ExportGenesis(ctx, k)
+	if len(exported.Storage) != 0 {
+		t.Errorf("exported storage %v", exported.Storage)
+	}
+	bz := ModuleCdc.MustMarshalJSON(exported)
+	data, err := UnmarshalGenesis(bz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateGenesis(data); err != nil {
+		t.Errorf("exported genesis %s is invalid: %s", bz, err)
+	}
+
+	data, err = UnmarshalGenesis([]byte(`{"swingset_pubkeys":[],"params":{},"storage":[{"path":"data.a","value":"1"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateGenesis(data); err == nil {
+		t.Error("validated a genesis with storage")
+	}
+	initCtx, initKeeper := newTestKeeper(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("InitGenesis took storage")
+		}
+		if initKeeper.GetStorage(initCtx, "data.a").Value != "" {
+			t.Error("InitGenesis set storage")
+		}
+	}()
+	InitGenesis(initCtx, initKeeper, data)
+}
@@ -4,51 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	// "github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
-type deliverInboundAction struct {
-	Type        string          `json:"type"`
-	Peer        string          `json:"peer"`
-	Messages    [][]interface{} `json:"messages"`
-	Ack         int             `json:"ack"`
-	StoragePort int             `json:"storagePort"`
-	BlockHeight int64           `json:"blockHeight"`
-	BlockTime   int64           `json:"blockTime"`
-}
-
-type beginBlockAction struct {
-	Type        string `json:"type"`
-	StoragePort int    `json:"storagePort"`
-	BlockHeight int64  `json:"blockHeight"`
-	BlockTime   int64  `json:"blockTime"`
-}
-
-// FIXME: Get rid of this global in exchange for a field on some object.
-var NodeMessageSender func(needReply bool, str string) (string, error)
-
-// FIXME: Get rid of this global in exchange for a method on some object.
-func SendToNode(str string) error {
-	_, err := NodeMessageSender(false, str)
-	return err
-}
-
-// FIXME: Get rid of this global in exchange for a method on some object.
-func CallToNode(str string) (string, error) {
-	return NodeMessageSender(true, str)
-}
-
 // NewHandler returns a handler for "swingset" type messages.
-func NewHandler(keeper Keeper) sdk.Handler {
+func NewHandler(keeper Keeper, bridge *Bridge) sdk.Handler {
 	return func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		switch msg := msg.(type) {
 		case MsgDeliverInbound:
-			return handleMsgDeliverInbound(ctx, keeper, msg)
+			return handleMsgDeliverInbound(ctx, keeper, bridge, msg)
 		default:
 			errMsg := fmt.Sprintf("Unrecognized swingset Msg type: %v", msg.Type())
 			return sdk.ErrUnknownRequest(errMsg).Result()
@@ -56,33 +27,133 @@ func NewHandler(keeper Keeper) sdk.Handler {
 	}
 }
 
-func BeginBlock(ctx sdk.Context, keeper Keeper) {
-	handleMsgBeginBlock(ctx, keeper)
-}
+// BeginBlock tells the kernel about the new block.  If the kernel rejects it
+// or runs out of gas, the BeginBlockFailurePolicy parameter decides what
+// happens next.  Any other failure, such as the controller going away or
+// timing out, is particular to this node, so it always halts rather than let
+// the node's state drift from the other validators'.  Each attempt runs
+// under a kernel savepoint, so that a failed one leaves neither storage
+// writes nor kernel effects behind.
+func BeginBlock(ctx sdk.Context, keeper Keeper, bridge *Bridge) {
+	if from := keeper.MigrateStore(ctx); from < StoreVersion {
+		ctx.Logger().Info("migrated swingset store", "from", from, "to", StoreVersion)
+	}
 
-type PortHandler interface {
-	Receive(string) (string, error)
-}
+	params := keeper.GetParams(ctx)
+	attempts := 1
+	if params.BeginBlockFailurePolicy == PolicyRetry {
+		attempts += int(params.MaxRetries)
+	}
 
-var portToHandler = map[int]PortHandler{}
-var lastPort = 0
+	var correlationID string
+	var err error
+	backoff := params.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		correlationID, err = beginBlockUnderSavepoint(ctx, keeper, bridge)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts || !isKernelError(err) {
+			failBeginBlock(ctx, keeper, bridge, params, attempt, correlationID, err)
+			return
+		}
+		ctx.Logger().Error("BEGIN_BLOCK failed; retrying",
+			"correlationId", correlationID, "attempt", attempt, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if status := keeper.GetKernelStatus(ctx); status.Degraded {
+		keeper.SetKernelStatus(ctx, KernelStatus{})
+		ctx.EventManager().EmitEvent(
+			sdk.NewEvent(
+				EventTypeKernelRecovered,
+				sdk.NewAttribute(AttributeKeyHeight, strconv.FormatInt(status.DegradedSince, 10)),
+			),
+		)
+	}
+}
 
-func RegisterPortHandler(portHandler PortHandler) int {
-	lastPort++
-	portToHandler[lastPort] = portHandler
-	return lastPort
+// beginBlockUnderSavepoint makes one attempt at BEGIN_BLOCK, whose storage
+// writes and kernel effects are kept only if it succeeds.
+func beginBlockUnderSavepoint(ctx sdk.Context, keeper Keeper, bridge *Bridge) (string, error) {
+	sp, err := bridge.Savepoint(ctx.Context())
+	if err != nil {
+		return "", err
+	}
+	cacheCtx, writeCache := ctx.CacheContext()
+	correlationID, err := handleMsgBeginBlock(cacheCtx, keeper, bridge)
+	if err != nil {
+		if abortErr := sp.Abort(ctx.Context()); abortErr != nil {
+			// No longer the kernel's rejection alone, so this halts.
+			return correlationID, fmt.Errorf("%s; then cannot abort savepoint: %s", err, abortErr)
+		}
+		return correlationID, err
+	}
+	if err := sp.Commit(ctx.Context()); err != nil {
+		return correlationID, err
+	}
+	writeCache()
+	ctx.EventManager().EmitEvents(cacheCtx.EventManager().Events())
+	return correlationID, nil
 }
-func UnregisterPortHandler(portNum int) error {
-	delete(portToHandler, portNum)
-	return nil
+
+// isKernelError says whether err is the kernel's rejection, which every
+// validator's kernel makes alike, rather than something that went wrong on
+// this node alone.
+func isKernelError(err error) bool {
+	_, ok := err.(*protocol.KernelError)
+	return ok
 }
 
-func ReceiveFromNode(portNum int, msg string) (string, error) {
-	handler := portToHandler[portNum]
-	if handler == nil {
-		return "", errors.New("Unregistered port " + fmt.Sprintf("%d", portNum))
+func failBeginBlock(ctx sdk.Context, keeper Keeper, bridge *Bridge, params Params, attempts int, correlationID string, err error) {
+	policy := params.BeginBlockFailurePolicy
+	if !isKernelError(err) {
+		policy = PolicyHalt
+	}
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			EventTypeBeginBlockFailed,
+			sdk.NewAttribute(AttributeKeyPolicy, policy),
+			sdk.NewAttribute(AttributeKeyAttempts, strconv.Itoa(attempts)),
+			sdk.NewAttribute(AttributeKeyCorrelationID, correlationID),
+		),
+	)
+
+	if policy == PolicyDegrade {
+		status := keeper.GetKernelStatus(ctx)
+		ctx.Logger().Error("BEGIN_BLOCK failed; kernel is degraded",
+			"correlationId", correlationID, "degradedSince", status.DegradedSince, "err", err)
+		if !status.Degraded {
+			keeper.SetKernelStatus(ctx, KernelStatus{Degraded: true, DegradedSince: ctx.BlockHeight()})
+			ctx.EventManager().EmitEvent(
+				sdk.NewEvent(
+					EventTypeKernelDegraded,
+					sdk.NewAttribute(AttributeKeyHeight, strconv.FormatInt(ctx.BlockHeight(), 10)),
+				),
+			)
+		}
+		return
 	}
-	return handler.Receive(msg)
+
+	// Halt, so that kernel and Cosmos state cannot drift apart.
+	dump, _ := json.Marshal(map[string]interface{}{
+		"height":        ctx.BlockHeight(),
+		"blockTime":     ctx.BlockTime(),
+		"policy":        policy,
+		"attempts":      attempts,
+		"correlationId": correlationID,
+		"error":         err.Error(),
+		"kernelStatus":  keeper.GetKernelStatus(ctx),
+		"bridge":        bridge.Diagnostics(),
+	})
+	ctx.Logger().Error("BEGIN_BLOCK failed; halting", "dump", string(dump))
+	panic(fmt.Sprintf("swingset: BEGIN_BLOCK failed at height %d after %d attempts: %s",
+		ctx.BlockHeight(), attempts, err))
+}
+
+func EndBlock(ctx sdk.Context, keeper Keeper, bridge *Bridge) {
+	bridge.Metrics().endBlock()
 }
 
 func mailboxPeer(key string) (string, error) {
@@ -93,68 +164,154 @@ func mailboxPeer(key string) (string, error) {
 	return path[1], nil
 }
 
-func handleMsgDeliverInbound(ctx sdk.Context, keeper Keeper, msg MsgDeliverInbound) sdk.Result {
-	messages := make([][]interface{}, len(msg.Messages))
+func handleMsgDeliverInbound(ctx sdk.Context, keeper Keeper, bridge *Bridge, msg MsgDeliverInbound) sdk.Result {
+	if status := keeper.GetKernelStatus(ctx); status.Degraded {
+		return ErrKernelDegraded(keeper.Codespace(), status.DegradedSince).Result()
+	}
+
+	messages := make([]protocol.Message, len(msg.Messages))
 	for i, message := range msg.Messages {
-		messages[i] = make([]interface{}, 2)
-		messages[i][0] = msg.Nums[i]
-		messages[i][1] = message
+		messages[i] = protocol.Message{Num: msg.Nums[i], Body: message}
+	}
+
+	// The kernel keeps what it did only if the tx succeeds: the savepoint is
+	// aborted here if the delivery fails, and otherwise left open for the
+	// app to end with the tx.  Storage is paid for by the tx.
+	sp, err := bridge.Savepoint(ctx.Context())
+	if err != nil {
+		return controllerFailed(keeper.Codespace(), bridge, fmt.Errorf("cannot set savepoint: %s", err)).Result()
+	}
+	fail := func(sdkErr sdk.Error) sdk.Result {
+		if err := sp.Abort(ctx.Context()); err != nil {
+			controllerFailed(keeper.Codespace(), bridge, fmt.Errorf("cannot abort savepoint: %s", err))
+		}
+		return sdkErr.Result()
 	}
 
 	storageHandler := NewStorageHandler(ctx, keeper)
-	// Allow the storageHandler to consume unlimited gas.
-	storageHandler.Context = storageHandler.Context.WithGasMeter(sdk.NewInfiniteGasMeter())
-
-	newPort := RegisterPortHandler(storageHandler)
-	action := &deliverInboundAction{
-		Type:        "DELIVER_INBOUND",
-		Peer:        msg.Peer,
-		Messages:    messages,
-		Ack:         msg.Ack,
-		StoragePort: newPort,
-		BlockHeight: ctx.BlockHeight(),
-		BlockTime:   ctx.BlockTime().Unix(),
+	storageHandler.Metrics = bridge.Metrics()
+	gasLimit, gasConsumed := storageHandler.GasMeter.Limit(), storageHandler.GasMeter.GasConsumed()
+
+	// Drop what the peer has acknowledged, so the kernel doesn't have to
+	// rewrite the whole outbox to do it.
+	trimmed, sdkErr := storageHandler.TrimOutbox(msg.Peer, uint64(msg.Ack))
+	if sdkErr != nil {
+		return fail(sdkErr)
+	}
+	bridge.Metrics().OutboxTrimmed.Add(float64(trimmed))
+
+	newPort := bridge.RegisterPortHandler(storageHandler)
+	bridge.recordGas(newPort, gasLimit, gasConsumed)
+	action := &protocol.DeliverInbound{
+		Type:          protocol.TypeDeliverInbound,
+		CorrelationID: protocol.NewCorrelationID(),
+		Peer:          msg.Peer,
+		Messages:      messages,
+		Ack:           msg.Ack,
+		StoragePort:   newPort,
+		BlockHeight:   ctx.BlockHeight(),
+		BlockTime:     ctx.BlockTime().Unix(),
 	}
 	b, err := json.Marshal(action)
 	if err != nil {
-		return sdk.ErrInternal(err.Error()).Result()
+		return fail(sdk.ErrInternal(err.Error()))
 	}
 
-	_, err = CallToNode(string(b))
-	// fmt.Fprintln(os.Stderr, "Returned from SwingSet", out, err)
-	UnregisterPortHandler(newPort)
+	bridge.Metrics().countDelivery()
+	out, err := bridge.CallToNode(ctx.Context(), string(b))
+	bridge.UnregisterPortHandler(newPort)
+	if storageHandler.OutOfGas != nil {
+		return fail(sdk.ErrOutOfGas(storageHandler.OutOfGas.Error()))
+	}
+	if storageHandler.Refused != nil {
+		return fail(storageHandler.Refused)
+	}
 	if err != nil {
-		return sdk.ErrInternal(err.Error()).Result()
+		ctx.Logger().Error("deliver inbound failed", "correlationId", action.CorrelationID, "err", err)
+		return fail(kernelError(keeper.Codespace(), bridge, err))
+	}
+	if err := protocol.CheckDoneReply(action.Type, out); err != nil {
+		return fail(ErrKernelFailed(keeper.Codespace(), err.Error()))
 	}
-	return sdk.Result{}
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			sdk.EventTypeMessage,
+			sdk.NewAttribute(sdk.AttributeKeyModule, AttributeValueCategory),
+			sdk.NewAttribute(sdk.AttributeKeySender, msg.Submitter.String()),
+			sdk.NewAttribute(AttributeKeyCorrelationID, action.CorrelationID),
+		),
+	)
+	return sdk.Result{Events: ctx.EventManager().Events()}
+}
+
+// kernelError tells apart the ways a call to the kernel can fail.  Only the
+// kernel's rejections, which every validator's kernel makes alike, become
+// result codes.
+func kernelError(codespace sdk.CodespaceType, bridge *Bridge, err error) sdk.Error {
+	ke, ok := err.(*protocol.KernelError)
+	if !ok {
+		return controllerFailed(codespace, bridge, err)
+	}
+	switch ke.Code {
+	case protocol.ErrorCodeMalformed:
+		return ErrMalformedMessage(codespace, ke.Error())
+	case protocol.ErrorCodeResourceExhausted:
+		return ErrResourceExhausted(codespace, ke.Error())
+	default:
+		return ErrKernelFailed(codespace, ke.Error())
+	}
+}
+
+// controllerFailed halts the bridge, so that the app halts the node at the
+// end of the tx, since a failure on this node alone must not become a result
+// that differs from the other validators'.
+func controllerFailed(codespace sdk.CodespaceType, bridge *Bridge, err error) sdk.Error {
+	bridge.Halt(err)
+	return ErrControllerUnavailable(codespace, err.Error())
 }
 
-func handleMsgBeginBlock(ctx sdk.Context, keeper Keeper) sdk.Result {
+func handleMsgBeginBlock(ctx sdk.Context, keeper Keeper, bridge *Bridge) (string, error) {
 	storageHandler := NewStorageHandler(ctx, keeper)
 
-	// Allow the storageHandler to consume unlimited gas.
-	storageHandler.Context = storageHandler.Context.WithGasMeter(sdk.NewInfiniteGasMeter())
+	// There's no tx to pay for storage, so it gets a budget.
+	if limit := keeper.GetParams(ctx).BeginBlockGasLimit; limit > 0 {
+		storageHandler.GasMeter = sdk.NewGasMeter(limit)
+	} else {
+		storageHandler.GasMeter = sdk.NewInfiniteGasMeter()
+	}
+	storageHandler.Metrics = bridge.Metrics()
 
-	newPort := RegisterPortHandler(storageHandler)
+	newPort := bridge.RegisterPortHandler(storageHandler)
+	bridge.recordGas(newPort, storageHandler.GasMeter.Limit(), 0)
 
-	action := &beginBlockAction{
-		Type:        "BEGIN_BLOCK",
-		BlockHeight: ctx.BlockHeight(),
-		BlockTime:   ctx.BlockTime().Unix(),
-		StoragePort: newPort,
+	action := &protocol.BeginBlock{
+		Type:          protocol.TypeBeginBlock,
+		CorrelationID: protocol.NewCorrelationID(),
+		BlockHeight:   ctx.BlockHeight(),
+		BlockTime:     ctx.BlockTime().Unix(),
+		StoragePort:   newPort,
 	}
 	b, err := json.Marshal(action)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error marshalling", err)
-		return sdk.ErrInternal(err.Error()).Result()
+		return action.CorrelationID, err
 	}
 
-	_, err = CallToNode(string(b))
-
-	// fmt.Fprintln(os.Stderr, "Returned from SwingSet", out, err)
-	UnregisterPortHandler(newPort)
-	if err != nil {
-		return sdk.ErrInternal(err.Error()).Result()
+	out, err := bridge.CallToNode(ctx.Context(), string(b))
+	bridge.UnregisterPortHandler(newPort)
+	if storageHandler.OutOfGas != nil {
+		// Every validator's kernel runs out alike.
+		return action.CorrelationID, &protocol.KernelError{
+			Code:    protocol.ErrorCodeResourceExhausted,
+			Message: fmt.Sprintf("BEGIN_BLOCK gas limit exceeded: %s", storageHandler.OutOfGas),
+		}
+	}
+	// A refused write was already answered to the kernel as a storage
+	// error, and it's up to the kernel whether that fails the block.
+	if storageHandler.Refused != nil {
+		ctx.Logger().Info("BEGIN_BLOCK storage write refused", "correlationId", action.CorrelationID, "err", storageHandler.Refused)
+	}
+	if err == nil {
+		err = protocol.CheckDoneReply(action.Type, out)
 	}
-	return sdk.Result{}
+	return action.CorrelationID, err
 }
@@ -0,0 +1,194 @@
+package keeper
+
+import (
+	"fmt"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// How many problems an invariant describes before it just counts the rest.
+const maxInvariantProblems = 10
+
+// Routes of the invariants of the swingset store
+const (
+	InvariantStorageIndex = "storage-index"
+	InvariantChildIndex   = "child-index"
+	InvariantMailboxes    = "mailboxes"
+	InvariantPeerIndex    = "peer-index"
+)
+
+type invariantRoute struct {
+	route     string
+	invariant func(Keeper) sdk.Invariant
+}
+
+var invariantRoutes = []invariantRoute{
+	{InvariantStorageIndex, StorageIndexInvariant},
+	{InvariantChildIndex, ChildIndexInvariant},
+	{InvariantMailboxes, MailboxesInvariant},
+	{InvariantPeerIndex, PeerIndexInvariant},
+}
+
+// RegisterInvariants registers the swingset store invariants with the crisis
+// module.
+func RegisterInvariants(ir sdk.InvariantRegistry, k Keeper) {
+	for _, r := range invariantRoutes {
+		ir.RegisterRoute(types.ModuleName, r.route, r.invariant(k))
+	}
+}
+
+// CheckInvariants runs the invariant at route, or all of them if route is
+// empty, and says what each found.
+func (k Keeper) CheckInvariants(ctx sdk.Context, route string) ([]types.InvariantResult, sdk.Error) {
+	var results []types.InvariantResult
+	for _, r := range invariantRoutes {
+		if route != "" && route != r.route {
+			continue
+		}
+		msg, broken := r.invariant(k)(ctx)
+		results = append(results, types.InvariantResult{Route: r.route, Broken: broken, Message: msg})
+	}
+	if results == nil {
+		return nil, sdk.ErrUnknownRequest(fmt.Sprintf("unknown swingset invariant %q", route))
+	}
+	return results, nil
+}
+
+// StorageIndexInvariant checks that every value is in its parent's child
+// index.
+func StorageIndexInvariant(k Keeper) sdk.Invariant {
+	return func(ctx sdk.Context) (string, bool) {
+		store := ctx.KVStore(k.storeKey)
+		var problems invariantProblems
+		iterator := sdk.KVStorePrefixIterator(store, []byte(dataPrefix))
+		defer iterator.Close()
+		for ; iterator.Valid(); iterator.Next() {
+			path := string(iterator.Key()[len(dataPrefix):])
+			if !store.Has(childKey(path)) {
+				problems.add("%s has a value but is not in its parent's child index", path)
+			}
+		}
+		return problems.format("values in the child index")
+	}
+}
+
+// ChildIndexInvariant checks that every child in the index has a value or
+// children of its own, and that no child lists from before StoreVersion 1
+// are left.
+func ChildIndexInvariant(k Keeper) sdk.Invariant {
+	return func(ctx sdk.Context) (string, bool) {
+		store := ctx.KVStore(k.storeKey)
+		var problems invariantProblems
+		iterator := sdk.KVStorePrefixIterator(store, []byte(childPrefix))
+		defer iterator.Close()
+		for ; iterator.Valid(); iterator.Next() {
+			entry := string(iterator.Key()[len(childPrefix):])
+			i := strings.Index(entry, childSeparator)
+			if i < 0 {
+				problems.add("malformed child index entry %q", entry)
+				continue
+			}
+			path := entry[i+len(childSeparator):]
+			if i > 0 {
+				path = entry[:i] + "." + path
+			}
+			if !store.Has(StorageKey(path)) && !hasChildren(store, path) {
+				problems.add("%s is in the child index but has no value or children", path)
+			}
+		}
+
+		legacy := sdk.KVStorePrefixIterator(store, []byte(legacyKeysPrefix))
+		defer legacy.Close()
+		for ; legacy.Valid(); legacy.Next() {
+			problems.add("%s has an unmigrated child list", legacy.Key()[len(legacyKeysPrefix):])
+		}
+		return problems.format("children with values")
+	}
+}
+
+// MailboxesInvariant checks that every peer's mailbox decodes and is in order.
+func MailboxesInvariant(k Keeper) sdk.Invariant {
+	return func(ctx sdk.Context) (string, bool) {
+		store := ctx.KVStore(k.storeKey)
+		var problems invariantProblems
+		iterator := sdk.KVStorePrefixIterator(store, StorageKey(mailboxPath+"."))
+		defer iterator.Close()
+		for ; iterator.Valid(); iterator.Next() {
+			path := string(iterator.Key()[len(dataPrefix):])
+			if !isMailboxPath(path) {
+				continue
+			}
+			value, err := UnmarshalValue(k.cdc, path, iterator.Value())
+			if err == nil {
+				_, err = types.ParseMailboxValue(value)
+			}
+			if err != nil {
+				problems.add("%s does not decode: %s", path, err)
+			}
+		}
+		return problems.format("mailboxes decode")
+	}
+}
+
+// PeerIndexInvariant checks that the index of peers lists exactly the peers
+// with mailboxes.
+func PeerIndexInvariant(k Keeper) sdk.Invariant {
+	return func(ctx sdk.Context) (string, bool) {
+		store := ctx.KVStore(k.storeKey)
+		var problems invariantProblems
+
+		indexed := make(map[string]bool)
+		iterator := k.GetPeersIterator(ctx)
+		defer iterator.Close()
+		for ; iterator.Valid(); iterator.Next() {
+			peer := PeerFromIterator(iterator)
+			indexed[peer] = true
+			if !store.Has(StorageKey(mailboxPath + "." + peer)) {
+				problems.add("peer %s is indexed but has no mailbox", peer)
+			}
+		}
+
+		mailboxes := sdk.KVStorePrefixIterator(store, StorageKey(mailboxPath+"."))
+		defer mailboxes.Close()
+		for ; mailboxes.Valid(); mailboxes.Next() {
+			path := string(mailboxes.Key()[len(dataPrefix):])
+			if isMailboxPath(path) && !indexed[path[len(mailboxPath)+1:]] {
+				problems.add("peer %s has a mailbox but is not indexed", path[len(mailboxPath)+1:])
+			}
+		}
+		return problems.format("peer index matches mailboxes")
+	}
+}
+
+// hasChildren says whether path has any entries in the child index.
+func hasChildren(store sdk.KVStore, path string) bool {
+	iterator := sdk.KVStorePrefixIterator(store, childrenPrefix(path))
+	defer iterator.Close()
+	return iterator.Valid()
+}
+
+// invariantProblems collects what an invariant found wrong.
+type invariantProblems struct {
+	lines []string
+	count int
+}
+
+func (p *invariantProblems) add(format string, args ...interface{}) {
+	p.count++
+	if len(p.lines) < maxInvariantProblems {
+		p.lines = append(p.lines, "\t"+fmt.Sprintf(format, args...)+"\n")
+	}
+}
+
+// format describes the problems as the crisis module expects, and says
+// whether there were any.
+func (p invariantProblems) format(name string) (string, bool) {
+	msg := fmt.Sprintf("found %d problems\n%s", p.count, strings.Join(p.lines, ""))
+	if p.count > len(p.lines) {
+		msg += fmt.Sprintf("\t... and %d more\n", p.count-len(p.lines))
+	}
+	return sdk.FormatInvariant(types.ModuleName, name, msg), p.count > 0
+}
@@ -1,130 +1,526 @@
 package keeper
 
 import (
-	"sort"
+	"encoding/binary"
+	"fmt"
 	"strings"
 
 	"github.com/cosmos/cosmos-sdk/codec"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/cosmos/cosmos-sdk/x/bank"
+	"github.com/cosmos/cosmos-sdk/x/params"
 	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
 )
 
+const (
+	statusKey  = "status"
+	versionKey = "version"
+
+	// The value of each storage path is at dataPrefix + path.
+	dataPrefix = "data:"
+
+	// Each child of a storage path has an entry at
+	// childPrefix + path + childSeparator + child, so the children can be
+	// found in order by iterating over a prefix.
+	childPrefix    = "child:"
+	childSeparator = "\x00"
+
+	// The storage used under each top-level path segment is at
+	// usagePrefix + namespace, from StoreVersion 2 on.
+	usagePrefix = "usage:"
+
+	// Before StoreVersion 1, the children of a path were one sorted list at
+	// legacyKeysPrefix + path.
+	legacyKeysPrefix = "keys:"
+
+	// Each peer's mailbox is at mailboxPath + "." + peer, so the children of
+	// mailboxPath are the index of peers.  From StoreVersion 3 on, the value
+	// there is a types.Mailbox rather than a types.Storage.
+	mailboxPath = "mailbox"
+
+	// A mailbox that can't be migrated to a types.Mailbox is moved from path
+	// to unmigratedPath + "." + path, where it keeps its old value.
+	unmigratedPath = "unmigrated"
+)
+
+// StoreVersion is the layout of the swingset store that this Keeper uses.
+// StoreVersion 4 only adds the RetryBackoff parameter, which MigrateStore
+// sets like any other that is missing.
+const StoreVersion uint64 = 4
+
+// The value of a child index entry, which is never nil.
+var childMarker = []byte{1}
+
+// How a Storage with an empty value is kept, since amino encodes it as
+// nothing, and the store can't hold nothing.
+var emptyStorageBytes = []byte{0x0a, 0x00}
+
 // Keeper maintains the link to data storage and exposes getter/setter methods for the various parts of the state machine
 type Keeper struct {
 	CoinKeeper bank.Keeper
 
 	storeKey sdk.StoreKey // Unexposed key to access store from sdk.Context
 
+	paramSpace params.Subspace
+
 	cdc *codec.Codec // The wire codec for binary encoding/decoding.
+
+	codespace sdk.CodespaceType
 }
 
 // NewKeeper creates new instances of the swingset Keeper
-func NewKeeper(coinKeeper bank.Keeper, storeKey sdk.StoreKey, cdc *codec.Codec) Keeper {
+func NewKeeper(coinKeeper bank.Keeper, storeKey sdk.StoreKey, paramSpace params.Subspace,
+	cdc *codec.Codec, codespace sdk.CodespaceType) Keeper {
 	return Keeper{
 		CoinKeeper: coinKeeper,
 		storeKey:   storeKey,
+		paramSpace: paramSpace.WithKeyTable(types.ParamKeyTable()),
 		cdc:        cdc,
+		codespace:  codespace,
 	}
 }
 
+// Codespace returns the keeper's codespace.
+func (k Keeper) Codespace() sdk.CodespaceType {
+	return k.codespace
+}
+
+// GetParams returns the total set of swingset parameters.
+func (k Keeper) GetParams(ctx sdk.Context) (params types.Params) {
+	k.paramSpace.GetParamSet(ctx, &params)
+	return params
+}
+
+// SetParams sets the total set of swingset parameters.
+func (k Keeper) SetParams(ctx sdk.Context, params types.Params) {
+	k.paramSpace.SetParamSet(ctx, &params)
+}
+
+// Gets how the kernel has been doing
+func (k Keeper) GetKernelStatus(ctx sdk.Context) types.KernelStatus {
+	store := ctx.KVStore(k.storeKey)
+	var status types.KernelStatus
+	bz := store.Get([]byte(statusKey))
+	if bz != nil {
+		k.cdc.MustUnmarshalBinaryBare(bz, &status)
+	}
+	return status
+}
+
+// Sets how the kernel has been doing
+func (k Keeper) SetKernelStatus(ctx sdk.Context, status types.KernelStatus) {
+	store := ctx.KVStore(k.storeKey)
+	if status == (types.KernelStatus{}) {
+		store.Delete([]byte(statusKey))
+		return
+	}
+	store.Set([]byte(statusKey), k.cdc.MustMarshalBinaryBare(status))
+}
+
+// StorageKey returns the store key that holds the value of path.
+func StorageKey(path string) []byte {
+	return []byte(dataPrefix + path)
+}
+
+// isMailboxPath says whether path holds a peer's mailbox.
+func isMailboxPath(path string) bool {
+	return Namespace(path) == mailboxPath && strings.Count(path, ".") == 1
+}
+
+// MarshalValue encodes the value at path as the store holds it.
+func MarshalValue(cdc *codec.Codec, path, value string) ([]byte, error) {
+	if isMailboxPath(path) {
+		mailbox, err := types.ParseMailboxValue(value)
+		if err != nil {
+			return nil, err
+		}
+		// Length-prefixed, since an empty mailbox would otherwise be nothing.
+		return cdc.MustMarshalBinaryLengthPrefixed(mailbox), nil
+	}
+	return marshalStorage(cdc, value), nil
+}
+
+func marshalStorage(cdc *codec.Codec, value string) []byte {
+	bz := cdc.MustMarshalBinaryBare(types.Storage{Value: value})
+	if len(bz) == 0 {
+		return emptyStorageBytes
+	}
+	return bz
+}
+
+// ValueEncodings returns each way that a state may hold value at path, newest
+// first.  States from before StoreVersion 3 hold mailboxes as types.Storage.
+func ValueEncodings(cdc *codec.Codec, path, value string) ([][]byte, error) {
+	bz, err := MarshalValue(cdc, path, value)
+	if err != nil {
+		return nil, err
+	}
+	if isMailboxPath(path) {
+		return [][]byte{bz, marshalStorage(cdc, value)}, nil
+	}
+	return [][]byte{bz}, nil
+}
+
+// UnmarshalValue decodes the value at path from how the store holds it,
+// including a mailbox from before StoreVersion 3.
+func UnmarshalValue(cdc *codec.Codec, path string, bz []byte) (string, error) {
+	if isMailboxPath(path) {
+		var mailbox types.Mailbox
+		if err := cdc.UnmarshalBinaryLengthPrefixed(bz, &mailbox); err == nil {
+			return mailbox.KernelValue(), nil
+		}
+	}
+	var storage types.Storage
+	if err := cdc.UnmarshalBinaryBare(bz, &storage); err != nil {
+		return "", err
+	}
+	return storage.Value, nil
+}
+
+func (k Keeper) unmarshalValue(path string, bz []byte) string {
+	value, err := UnmarshalValue(k.cdc, path, bz)
+	if err != nil {
+		panic(fmt.Sprintf("cannot decode %s: %s", path, err))
+	}
+	return value
+}
+
+// canonicalValue checks that value can be kept at path, and returns it as it
+// will be read back.  A mailbox is kept parsed, so it is refused unless it
+// reads back byte for byte as the kernel wrote it.
+func (k Keeper) canonicalValue(path, value string) (string, sdk.Error) {
+	if !isMailboxPath(path) {
+		return value, nil
+	}
+	mailbox, err := types.ParseMailboxValue(value)
+	if err != nil {
+		return "", types.ErrInvalidMailbox(k.codespace, fmt.Sprintf("%s: %s", path, err))
+	}
+	if kernelValue := mailbox.KernelValue(); kernelValue != value {
+		return "", types.ErrInvalidMailbox(k.codespace,
+			fmt.Sprintf("%s: not as the kernel writes it, which is %s", path, kernelValue))
+	}
+	return value, nil
+}
+
+// childKey returns the key of path's entry in its parent's child index.
+func childKey(path string) []byte {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return append(childrenPrefix(""), path...)
+	}
+	return append(childrenPrefix(path[:i]), path[i+1:]...)
+}
+
 // Gets generic storage
 func (k Keeper) GetStorage(ctx sdk.Context, path string) types.Storage {
 	//fmt.Printf("GetStorage(%s)\n", path);
 	store := ctx.KVStore(k.storeKey)
-	fullPath := "data:" + path
+	fullPath := dataPrefix + path
 	if !store.Has([]byte(fullPath)) {
-		return types.Storage{""}
+		return types.Storage{Value: ""}
 	}
 	bz := store.Get([]byte(fullPath))
-	var storage types.Storage
-	k.cdc.MustUnmarshalBinaryBare(bz, &storage)
-	return storage
+	return types.Storage{Value: k.unmarshalValue(path, bz)}
 }
 
+func childrenPrefix(path string) []byte {
+	return []byte(childPrefix + path + childSeparator)
+}
+
+// Gets all the child keys of path, in order
 func (k Keeper) GetKeys(ctx sdk.Context, path string) types.Keys {
+	keys, _ := k.GetKeysPage(ctx, path, "", 0)
+	return types.Keys{Keys: keys}
+}
+
+// GetKeysPage returns up to limit child keys of path, beginning with start,
+// and the key that begins the next page, or "" if there are no more.  A
+// limit of zero means no limit.
+func (k Keeper) GetKeysPage(ctx sdk.Context, path, start string, limit int) ([]string, string) {
 	store := ctx.KVStore(k.storeKey)
-	fullPath := "keys:" + path
-	if !store.Has([]byte(fullPath)) {
-		return types.NewKeys()
+	prefix := childrenPrefix(path)
+	iterator := store.Iterator(append(prefix, start...), sdk.PrefixEndBytes(prefix))
+	defer iterator.Close()
+
+	var keys []string
+	for ; iterator.Valid(); iterator.Next() {
+		key := string(iterator.Key()[len(prefix):])
+		if limit > 0 && len(keys) == limit {
+			return keys, key
+		}
+		keys = append(keys, key)
 	}
-	bz := store.Get([]byte(fullPath))
-	var keys types.Keys
-	k.cdc.MustUnmarshalBinaryBare(bz, &keys)
-	return keys
+	return keys, ""
+}
+
+// GetEntriesPage is like GetKeysPage, but returns each key's value with it.
+// A key with only children has no value.
+func (k Keeper) GetEntriesPage(ctx sdk.Context, path, start string, limit int) ([]types.KeyValue, string) {
+	keys, next := k.GetKeysPage(ctx, path, start, limit)
+	entries := make([]types.KeyValue, len(keys))
+	for i, key := range keys {
+		entries[i] = types.KeyValue{Key: key}
+		if keyPath := childPath(path, key); k.HasStorage(ctx, keyPath) {
+			value := k.GetStorage(ctx, keyPath).Value
+			entries[i].Value = &value
+		}
+	}
+	return entries, next
+}
+
+// childPath returns the path of key under path, where the children of the
+// empty path are the top-level paths.
+func childPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
 }
 
-// Sets the entire generic storage for a path
-func (k Keeper) SetStorage(ctx sdk.Context, path string, storage types.Storage) {
+// Says whether path has a value, even an empty one
+func (k Keeper) HasStorage(ctx sdk.Context, path string) bool {
 	store := ctx.KVStore(k.storeKey)
+	return store.Has(StorageKey(path))
+}
 
-	fullPath := "data:" + path
-	fullPathArray := strings.Split(path, ".")
-	oneUp := strings.Join(fullPathArray[0:len(fullPathArray)-1], ".")
-	lastKey := fullPathArray[len(fullPathArray)-1]
+// Sets the entire generic storage for a path, unless that would put its
+// namespace over quota
+func (k Keeper) SetStorage(ctx sdk.Context, path string, storage types.Storage) sdk.Error {
+	value, err := k.canonicalValue(path, storage.Value)
+	if err != nil {
+		return err
+	}
+	storage.Value = value
 
-	// Get a map corresponding to the keys for the parent key.
-	keyNode := k.GetKeys(ctx, oneUp)
-	keyList := keyNode.Keys
-	keyMap := make(map[string]bool, len(keyList)+1)
-	for _, key := range keyList {
-		keyMap[key] = true
+	var deltas usageDeltas
+	if k.HasStorage(ctx, path) {
+		deltas.change(path, k.GetStorage(ctx, path).Value, -1)
 	}
+	deltas.change(path, storage.Value, 1)
+	if err := k.updateStorageUsage(ctx, deltas); err != nil {
+		return err
+	}
+
+	k.writeStorage(ctx, path, storage)
+	return nil
+}
 
-	if storage.Value == "" {
-		delete(keyMap, lastKey)
-	} else {
-		keyMap[lastKey] = true
+// Deletes the value at path, if it has one
+func (k Keeper) DeleteStorage(ctx sdk.Context, path string) {
+	if !k.HasStorage(ctx, path) {
+		return
 	}
+	var deltas usageDeltas
+	deltas.change(path, k.GetStorage(ctx, path).Value, -1)
+	// Shrinking is never over quota.
+	_ = k.updateStorageUsage(ctx, deltas)
 
-	// Update the list of keys
-	keyList = make([]string, 0, len(keyMap))
-	for key := range keyMap {
-		keyList = append(keyList, key)
+	k.removeStorage(ctx, path)
+}
+
+// writeStorage sets the value at path and its parent's index, without
+// accounting for it or checking it.
+func (k Keeper) writeStorage(ctx sdk.Context, path string, storage types.Storage) {
+	bz, err := MarshalValue(k.cdc, path, storage.Value)
+	if err != nil {
+		panic(fmt.Sprintf("cannot encode %s: %s", path, err))
 	}
+	store := ctx.KVStore(k.storeKey)
+	store.Set(StorageKey(path), bz)
+	store.Set(childKey(path), childMarker)
+}
+
+// removeStorage deletes the value at path and its parent's index entry,
+// without accounting for it.
+func (k Keeper) removeStorage(ctx sdk.Context, path string) {
+	store := ctx.KVStore(k.storeKey)
+	store.Delete(StorageKey(path))
+	store.Delete(childKey(path))
+}
 
-	// Update the value.
-	if storage.Value == "" {
-		store.Delete([]byte(fullPath))
-	} else {
-		store.Set([]byte(fullPath), k.cdc.MustMarshalBinaryBare(storage))
+// Gets the layout version of the store
+func (k Keeper) GetStoreVersion(ctx sdk.Context) uint64 {
+	store := ctx.KVStore(k.storeKey)
+	bz := store.Get([]byte(versionKey))
+	if bz == nil {
+		return 0
 	}
+	return binary.BigEndian.Uint64(bz)
+}
 
-	// Update the keys.
-	keysPath := "keys:" + oneUp
-	if len(keyList) == 0 {
-		store.Delete([]byte(keysPath))
-	} else {
-		// Update the key node.
-		sort.Strings(keyList)
-		keyNode.Keys = keyList
-		store.Set([]byte(keysPath), k.cdc.MustMarshalBinaryBare(keyNode))
+// Sets the layout version of the store
+func (k Keeper) SetStoreVersion(ctx sdk.Context, version uint64) {
+	store := ctx.KVStore(k.storeKey)
+	store.Set([]byte(versionKey), sdk.Uint64ToBigEndian(version))
+}
+
+// MigrateStore brings the store up to StoreVersion, returning the version it
+// started at.  Parameters added since are given their defaults, so adding a
+// parameter needs a new StoreVersion.
+func (k Keeper) MigrateStore(ctx sdk.Context) uint64 {
+	from := k.GetStoreVersion(ctx)
+	if from >= StoreVersion {
+		return from
+	}
+	k.setMissingParams(ctx)
+	if from < 1 {
+		k.migrateLegacyKeys(ctx)
 	}
+	if from < 2 {
+		k.migrateStorageUsage(ctx)
+	}
+	if from < 3 {
+		k.migrateMailboxes(ctx)
+	}
+	k.SetStoreVersion(ctx, StoreVersion)
+	return from
+}
+
+// setMissingParams sets the parameters that aren't in the store yet to their
+// defaults.
+func (k Keeper) setMissingParams(ctx sdk.Context) {
+	defaults := types.DefaultParams()
+	for _, pair := range defaults.ParamSetPairs() {
+		if !k.paramSpace.Has(ctx, pair.Key) {
+			k.paramSpace.Set(ctx, pair.Key, pair.Value)
+		}
+	}
+}
+
+// migrateLegacyKeys replaces each sorted list of children with child index
+// entries.
+func (k Keeper) migrateLegacyKeys(ctx sdk.Context) {
+	store := ctx.KVStore(k.storeKey)
+
+	// Don't write to the store while iterating over it.
+	var legacyPaths [][]byte
+	iterator := sdk.KVStorePrefixIterator(store, []byte(legacyKeysPrefix))
+	for ; iterator.Valid(); iterator.Next() {
+		legacyPaths = append(legacyPaths, append([]byte(nil), iterator.Key()...))
+	}
+	iterator.Close()
+
+	for _, legacyPath := range legacyPaths {
+		var keys types.Keys
+		k.cdc.MustUnmarshalBinaryBare(store.Get(legacyPath), &keys)
+		prefix := childrenPrefix(string(legacyPath[len(legacyKeysPrefix):]))
+		for _, key := range keys.Keys {
+			store.Set(append(prefix[:len(prefix):len(prefix)], key...), childMarker)
+		}
+		store.Delete(legacyPath)
+	}
+}
+
+// migrateMailboxes keeps each peer's mailbox as a types.Mailbox, and recounts
+// the usage of the mailbox namespace, since that changes how the values read.
+// Mailboxes that don't parse are moved aside rather than halt the chain.
+func (k Keeper) migrateMailboxes(ctx sdk.Context) {
+	store := ctx.KVStore(k.storeKey)
+
+	// Don't write to the store while iterating over it.
+	var entries []types.StorageEntry
+	iterator := sdk.KVStorePrefixIterator(store, StorageKey(mailboxPath))
+	for ; iterator.Valid(); iterator.Next() {
+		path := string(iterator.Key()[len(dataPrefix):])
+		if Namespace(path) != mailboxPath {
+			continue
+		}
+		var storage types.Storage
+		k.cdc.MustUnmarshalBinaryBare(iterator.Value(), &storage)
+		entries = append(entries, types.StorageEntry{Path: path, Value: storage.Value})
+	}
+	iterator.Close()
+
+	var usage types.StorageUsage
+	aside := k.GetStorageUsage(ctx, unmigratedPath)
+	for _, entry := range entries {
+		if isMailboxPath(entry.Path) {
+			mailbox, err := types.ParseMailboxValue(entry.Value)
+			if err != nil {
+				asidePath := unmigratedPath + "." + entry.Path
+				ctx.Logger().Error("cannot migrate mailbox; moving it aside",
+					"path", entry.Path, "to", asidePath, "err", err)
+				k.removeStorage(ctx, entry.Path)
+				k.writeStorage(ctx, asidePath, types.Storage{Value: entry.Value})
+				aside.Bytes += entrySize(asidePath, entry.Value)
+				aside.Entries++
+				continue
+			}
+			entry.Value = mailbox.KernelValue()
+			store.Set(StorageKey(entry.Path), k.cdc.MustMarshalBinaryLengthPrefixed(mailbox))
+		}
+		usage.Bytes += entrySize(entry.Path, entry.Value)
+		usage.Entries++
+	}
+	k.setStorageUsage(ctx, mailboxPath, usage)
+	k.setStorageUsage(ctx, unmigratedPath, aside)
+}
+
+// Says whether peer has a mailbox
+func (k Keeper) HasMailbox(ctx sdk.Context, peer string) bool {
+	return k.HasStorage(ctx, mailboxPath+"."+peer)
 }
 
 // Gets the entire mailbox struct for a peer
-func (k Keeper) GetMailbox(ctx sdk.Context, peer string) types.Storage {
+func (k Keeper) GetMailbox(ctx sdk.Context, peer string) types.Mailbox {
 	store := ctx.KVStore(k.storeKey)
-	path := "data:mailbox." + peer
-	if !store.Has([]byte(path)) {
+	path := StorageKey(mailboxPath + "." + peer)
+	if !store.Has(path) {
 		return types.NewMailbox()
 	}
-	bz := store.Get([]byte(path))
-	var mailbox types.Storage
-	k.cdc.MustUnmarshalBinaryBare(bz, &mailbox)
+	value := k.unmarshalValue(mailboxPath+"."+peer, store.Get(path))
+	mailbox, err := types.ParseMailboxValue(value)
+	if err != nil {
+		panic(fmt.Sprintf("cannot decode mailbox for %s: %s", peer, err))
+	}
 	return mailbox
 }
 
 // Sets the entire mailbox struct for a peer
-func (k Keeper) SetMailbox(ctx sdk.Context, peer string, mailbox types.Storage) {
-	store := ctx.KVStore(k.storeKey)
-	path := "data:mailbox." + peer
-	store.Set([]byte(path), k.cdc.MustMarshalBinaryBare(mailbox))
+func (k Keeper) SetMailbox(ctx sdk.Context, peer string, mailbox types.Mailbox) sdk.Error {
+	if err := mailbox.Validate(); err != nil {
+		return types.ErrInvalidMailbox(k.codespace, fmt.Sprintf("%s: %s", peer, err))
+	}
+	return k.SetStorage(ctx, mailboxPath+"."+peer, types.Storage{Value: mailbox.KernelValue()})
 }
 
-// Get an iterator over all peers in which the keys are the peers and the values are the mailbox
+// TrimOutbox drops the messages in peer's outbox numbered up to ack, which
+// the peer has already received, and returns how many it dropped.
+func (k Keeper) TrimOutbox(ctx sdk.Context, peer string, ack uint64) (int, sdk.Error) {
+	mailbox := k.GetMailbox(ctx, peer)
+	n := 0
+	for n < len(mailbox.Outbox) && mailbox.Outbox[n].Num <= ack {
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	mailbox.Outbox = mailbox.Outbox[n:]
+	return n, k.SetMailbox(ctx, peer, mailbox)
+}
+
+// GetPeersPage returns up to limit peers with mailboxes, beginning with
+// start, and the peer that begins the next page, or "" if there are no more.
+// A limit of zero means no limit.
+func (k Keeper) GetPeersPage(ctx sdk.Context, start string, limit int) ([]types.PeerInfo, string) {
+	peers, next := k.GetKeysPage(ctx, mailboxPath, start, limit)
+	infos := make([]types.PeerInfo, len(peers))
+	for i, peer := range peers {
+		infos[i] = types.NewPeerInfo(peer, k.GetMailbox(ctx, peer))
+	}
+	return infos, next
+}
+
+// Get an iterator over all peers in which the keys are the peers' index entries
+// and the values are meaningless; use PeerFromIterator to get the peer
 func (k Keeper) GetPeersIterator(ctx sdk.Context) sdk.Iterator {
 	store := ctx.KVStore(k.storeKey)
-	return sdk.KVStorePrefixIterator(store, nil)
+	return sdk.KVStorePrefixIterator(store, childrenPrefix(mailboxPath))
+}
+
+// PeerFromIterator returns the peer at an iterator from GetPeersIterator
+func PeerFromIterator(iterator sdk.Iterator) string {
+	return string(iterator.Key()[len(childrenPrefix(mailboxPath)):])
 }
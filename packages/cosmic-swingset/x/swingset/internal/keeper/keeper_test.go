@@ -0,0 +1,277 @@
+package keeper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/params"
+	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// newTestKeeper returns a Keeper on an in-memory store, and a context for it.
+// The store is empty, so it has no parameters until SetParams.
+func newTestKeeper(t *testing.T) (sdk.Context, Keeper) {
+	t.Helper()
+	key := sdk.NewKVStoreKey(types.StoreKey)
+	paramsKey := sdk.NewKVStoreKey(params.StoreKey)
+	paramsTKey := sdk.NewTransientStoreKey(params.TStoreKey)
+
+	db := dbm.NewMemDB()
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(key, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(paramsKey, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(paramsTKey, sdk.StoreTypeTransient, db)
+	if err := ms.LoadLatestVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	cdc := codec.New()
+	types.RegisterCodec(cdc)
+	paramsKeeper := params.NewKeeper(cdc, paramsKey, paramsTKey, params.DefaultCodespace)
+	k := NewKeeper(nil, key, paramsKeeper.Subspace(types.DefaultParamspace), cdc, types.DefaultCodespace)
+	ctx := sdk.NewContext(ms, abci.Header{Height: 1}, false, log.NewNopLogger())
+	return ctx, k
+}
+
+// initTestKeeper is newTestKeeper for a store of this StoreVersion, as
+// InitGenesis would leave it.
+func initTestKeeper(t *testing.T) (sdk.Context, Keeper) {
+	t.Helper()
+	ctx, k := newTestKeeper(t)
+	k.SetParams(ctx, types.DefaultParams())
+	k.SetStoreVersion(ctx, StoreVersion)
+	return ctx, k
+}
+
+// kernelMailboxValue returns a mailbox as the kernel writes it to storage:
+// deterministic-json of {outbox, ack}, encoded again as a JSON string by
+// JSON.stringify.  Being JSON, data has no control characters, so that only
+// escapes quotes and backslashes.
+func kernelMailboxValue(t *testing.T, data string) string {
+	t.Helper()
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(data) + `"`
+}
+
+// checkInvariants fails the test if any store invariant is broken.
+func checkInvariants(t *testing.T, ctx sdk.Context, k Keeper) {
+	t.Helper()
+	results, err := k.CheckInvariants(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Broken {
+			t.Errorf("invariant %s is broken: %s", result.Route, result.Message)
+		}
+	}
+}
+
+func TestMailboxKernelValueRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		`{"ack":0,"outbox":[]}`,
+		`{"ack":7,"outbox":[[1,"hello"]]}`,
+		`{"ack":2,"outbox":[[3,"{\"a\":[1,2]}"],[4,""],[9,"<tag> & 'quote'"]]}`,
+		`{"ack":1,"outbox":[[1,"ünïcødé ☃"],[2,"tab\tnewline\n"]]}`,
+		// JSON.stringify leaves these separators alone, unlike encoding/json.
+		`{"ack":1,"outbox":[[1,"line` + "\u2028" + `paragraph` + "\u2029" + `"]]}`,
+		`{"ack":0,"outbox":[[1,"\b\f\r\u0000\u001f"]]}`,
+	} {
+		value := kernelMailboxValue(t, data)
+
+		mailbox, err := types.ParseMailboxValue(value)
+		if err != nil {
+			t.Errorf("ParseMailboxValue(%s): %s", data, err)
+			continue
+		}
+		if got := mailbox.KernelValue(); got != value {
+			t.Errorf("KernelValue() = %s, want %s", got, value)
+		}
+
+		ctx, k := initTestKeeper(t)
+		if err := k.SetStorage(ctx, "mailbox.peer", types.Storage{Value: value}); err != nil {
+			t.Fatalf("SetStorage(%s): %s", data, err)
+		}
+		if got := k.GetStorage(ctx, "mailbox.peer").Value; got != value {
+			t.Errorf("GetStorage() = %s, want %s", got, value)
+		}
+		if got := k.GetMailbox(ctx, "peer"); got.Ack != mailbox.Ack || len(got.Outbox) != len(mailbox.Outbox) {
+			t.Errorf("GetMailbox() = %v, want %v", got, mailbox)
+		}
+		if got := k.GetStorageUsage(ctx, mailboxPath); got.Bytes != entrySize("mailbox.peer", value) {
+			t.Errorf("mailbox usage = %d bytes, want %d", got.Bytes, entrySize("mailbox.peer", value))
+		}
+	}
+}
+
+func TestSetStorageRejectsMailboxNotAsKernelWrites(t *testing.T) {
+	// Each parses, but wouldn't read back as written.
+	for _, data := range []string{
+		`{"ack":1,"outbox":[[1,"line\u2028"]]}`,
+		`{"ack":0,"outbox":[[1,"\u0008"]]}`,
+		`{"ack":0,"outbox":[[1,"\u001F"]]}`,
+		`{"ack":0,"outbox":[[1,"\/"]]}`,
+		// A lone surrogate, and invalid UTF-8, each decode to U+FFFD.
+		`{"ack":0,"outbox":[[1,"\ud800"]]}`,
+		`{"ack":0,"outbox":[[1,"` + "\xff" + `"]]}`,
+		`{"outbox":[],"ack":0}`,
+		`{"ack": 0, "outbox": []}`,
+	} {
+		value := kernelMailboxValue(t, data)
+		if _, err := types.ParseMailboxValue(value); err != nil {
+			t.Errorf("ParseMailboxValue(%s): %s", data, err)
+		}
+		ctx, k := initTestKeeper(t)
+		if err := k.SetStorage(ctx, "mailbox.peer", types.Storage{Value: value}); err == nil {
+			t.Errorf("SetStorage(%s) succeeded", data)
+		}
+		if k.HasMailbox(ctx, "peer") {
+			t.Errorf("%s was stored", data)
+		}
+	}
+}
+
+func TestSetStorageRejectsMalformedMailbox(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	for _, value := range []string{
+		`not json`,
+		kernelMailboxValue(t, `{"ack":0,"outbox":[[1]]}`),
+		kernelMailboxValue(t, `{"ack":0,"outbox":[[2,"b"],[1,"a"]]}`),
+	} {
+		if err := k.SetStorage(ctx, "mailbox.peer", types.Storage{Value: value}); err == nil {
+			t.Errorf("SetStorage(%s) succeeded", value)
+		}
+	}
+	if k.HasMailbox(ctx, "peer") {
+		t.Error("a malformed mailbox was stored")
+	}
+}
+
+// legacyStore writes entries as a store from before StoreVersion 1 held
+// them: every value a types.Storage, and the children of each path in a
+// sorted list.  Nothing is indexed or accounted for.
+func legacyStore(t *testing.T, ctx sdk.Context, k Keeper, entries []types.StorageEntry) {
+	t.Helper()
+	store := ctx.KVStore(k.storeKey)
+	children := make(map[string][]string)
+	var parents []string
+	for _, e := range entries {
+		store.Set(StorageKey(e.Path), marshalStorage(k.cdc, e.Value))
+		parent, key := "", e.Path
+		if i := strings.LastIndex(e.Path, "."); i >= 0 {
+			parent, key = e.Path[:i], e.Path[i+1:]
+		}
+		if _, ok := children[parent]; !ok {
+			parents = append(parents, parent)
+		}
+		children[parent] = append(children[parent], key)
+	}
+	for _, parent := range parents {
+		store.Set([]byte(legacyKeysPrefix+parent), k.cdc.MustMarshalBinaryBare(types.Keys{Keys: children[parent]}))
+	}
+}
+
+func TestMigrateStoreFromLegacyKeys(t *testing.T) {
+	ctx, k := newTestKeeper(t)
+	mailbox := kernelMailboxValue(t, `{"ack":1,"outbox":[[2,"hi"]]}`)
+	entries := []types.StorageEntry{
+		{Path: "data.a", Value: "1"},
+		{Path: "data.b", Value: ""},
+		{Path: "data.b.c", Value: "three"},
+		{Path: "mailbox.peer1", Value: mailbox},
+	}
+	legacyStore(t, ctx, k, entries)
+
+	if from := k.MigrateStore(ctx); from != 0 {
+		t.Errorf("MigrateStore() = %d, want 0", from)
+	}
+	if v := k.GetStoreVersion(ctx); v != StoreVersion {
+		t.Errorf("store version = %d, want %d", v, StoreVersion)
+	}
+	if got := k.GetParams(ctx); got.String() != types.DefaultParams().String() {
+		t.Errorf("params = %s, want the defaults", got)
+	}
+
+	// The child lists are now an index.
+	for path, want := range map[string][]string{"data": {"a", "b"}, "data.b": {"c"}, "mailbox": {"peer1"}} {
+		got := k.GetKeys(ctx, path).Keys
+		if len(got) != len(want) {
+			t.Errorf("GetKeys(%s) = %v, want %v", path, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("GetKeys(%s) = %v, want %v", path, got, want)
+			}
+		}
+	}
+
+	// Every value reads back as it was written.
+	for _, e := range entries {
+		if got := k.GetStorage(ctx, e.Path).Value; got != e.Value {
+			t.Errorf("GetStorage(%s) = %q, want %q", e.Path, got, e.Value)
+		}
+	}
+	if got := k.GetMailbox(ctx, "peer1"); got.Ack != 1 || len(got.Outbox) != 1 || got.Outbox[0].Body != "hi" {
+		t.Errorf("GetMailbox(peer1) = %v", got)
+	}
+
+	// And is accounted for in its namespace.
+	wantUsage := map[string]types.StorageUsage{}
+	for _, e := range entries {
+		u := wantUsage[Namespace(e.Path)]
+		u.Bytes += entrySize(e.Path, e.Value)
+		u.Entries++
+		wantUsage[Namespace(e.Path)] = u
+	}
+	for namespace, want := range wantUsage {
+		if got := k.GetStorageUsage(ctx, namespace); got != want {
+			t.Errorf("usage of %s = %+v, want %+v", namespace, got, want)
+		}
+	}
+	checkInvariants(t, ctx, k)
+
+	// Migrating again does nothing.
+	if from := k.MigrateStore(ctx); from != StoreVersion {
+		t.Errorf("second MigrateStore() = %d, want %d", from, StoreVersion)
+	}
+}
+
+func TestMigrateStoreMovesAsideUnparsableMailboxes(t *testing.T) {
+	ctx, k := newTestKeeper(t)
+	good := kernelMailboxValue(t, `{"ack":0,"outbox":[]}`)
+	bad := `{"outbox":`
+	legacyStore(t, ctx, k, []types.StorageEntry{
+		{Path: "mailbox.bad", Value: bad},
+		{Path: "mailbox.good", Value: good},
+	})
+
+	k.MigrateStore(ctx)
+
+	if k.HasMailbox(ctx, "bad") {
+		t.Error("the unparsable mailbox is still in place")
+	}
+	if got := k.GetStorage(ctx, "unmigrated.mailbox.bad").Value; got != bad {
+		t.Errorf("moved-aside mailbox = %q, want %q", got, bad)
+	}
+	if got := k.GetStorage(ctx, "mailbox.good").Value; got != good {
+		t.Errorf("GetStorage(mailbox.good) = %q, want %q", got, good)
+	}
+	if got, want := k.GetStorageUsage(ctx, mailboxPath), (types.StorageUsage{
+		Bytes: entrySize("mailbox.good", good), Entries: 1,
+	}); got != want {
+		t.Errorf("mailbox usage = %+v, want %+v", got, want)
+	}
+	if got, want := k.GetStorageUsage(ctx, unmigratedPath), (types.StorageUsage{
+		Bytes: entrySize("unmigrated.mailbox.bad", bad), Entries: 1,
+	}); got != want {
+		t.Errorf("unmigrated usage = %+v, want %+v", got, want)
+	}
+	checkInvariants(t, ctx, k)
+}
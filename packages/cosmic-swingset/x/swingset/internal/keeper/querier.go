@@ -1,6 +1,7 @@
 package keeper
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/cosmos/cosmos-sdk/codec"
@@ -15,6 +16,22 @@ const (
 	QueryMailbox = "mailbox"
 	QueryStorage = "storage"
 	QueryKeys    = "keys"
+	QueryStatus  = "status"
+	QueryUsage   = "usage"
+	QueryPeers   = "peers"
+	QueryHas     = "has"
+	QueryEntries = "entries"
+	QueryValues  = "values"
+	QuerySize    = "size"
+	QueryTree    = "tree"
+)
+
+// The default and greatest depths of a tree query
+const (
+	DefaultTreeDepth = 1
+	MaxTreeDepth     = 16
+	// A tree is truncated once it has this many nodes.
+	MaxTreeNodes = 1000
 )
 
 // NewQuerier is the module level router for state queries
@@ -27,6 +44,22 @@ func NewQuerier(keeper Keeper) sdk.Querier {
 			return queryKeys(ctx, strings.Join(path[1:], "/"), req, keeper)
 		case QueryMailbox:
 			return queryMailbox(ctx, path[1:], req, keeper)
+		case QueryStatus:
+			return queryStatus(ctx, req, keeper)
+		case QueryUsage:
+			return queryUsage(ctx, path[1:], req, keeper)
+		case QueryPeers:
+			return queryPeers(ctx, req, keeper)
+		case QueryHas:
+			return queryHas(ctx, strings.Join(path[1:], "/"), req, keeper)
+		case QueryEntries:
+			return queryEntries(ctx, strings.Join(path[1:], "/"), req, keeper)
+		case QueryValues:
+			return queryValues(ctx, strings.Join(path[1:], "/"), req, keeper)
+		case QuerySize:
+			return querySize(ctx, strings.Join(path[1:], "/"), req, keeper)
+		case QueryTree:
+			return queryTree(ctx, strings.Join(path[1:], "/"), req, keeper)
 		default:
 			return nil, sdk.ErrUnknownRequest("unknown swingset query endpoint")
 		}
@@ -35,14 +68,12 @@ func NewQuerier(keeper Keeper) sdk.Querier {
 
 // nolint: unparam
 func queryStorage(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
-	storage := keeper.GetStorage(ctx, path)
-	value := storage.Value
-
-	if value == "" {
+	if !keeper.HasStorage(ctx, path) {
 		return []byte{}, sdk.ErrUnknownRequest("could not get storage")
 	}
+	value := keeper.GetStorage(ctx, path).Value
 
-	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResStorage{value})
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResStorage{Value: value})
 	if err2 != nil {
 		panic("could not marshal result to JSON")
 	}
@@ -52,14 +83,16 @@ func queryStorage(ctx sdk.Context, path string, req abci.RequestQuery, keeper Ke
 
 // nolint: unparam
 func queryKeys(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
-	keys := keeper.GetKeys(ctx, path)
-	klist := keys.Keys
-
+	params, err := pageParams(req, keeper)
+	if err != nil {
+		return []byte{}, err
+	}
+	klist, next := keeper.GetKeysPage(ctx, path, params.Start, params.Limit)
 	if klist == nil {
-		return []byte{}, sdk.ErrUnknownRequest("could not get keys")
+		klist = []string{}
 	}
 
-	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResKeys{klist})
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResKeys{Keys: klist, Next: next})
 	if err2 != nil {
 		panic("could not marshal result to JSON")
 	}
@@ -70,15 +103,163 @@ func queryKeys(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keepe
 // nolint: unparam
 func queryMailbox(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
 	peer := path[0]
+	if !keeper.HasMailbox(ctx, peer) {
+		return []byte{}, sdk.ErrUnknownRequest("could not get peer mailbox")
+	}
 
 	mailbox := keeper.GetMailbox(ctx, peer)
-	value := mailbox.Value
 
-	if value == "" {
-		return []byte{}, sdk.ErrUnknownRequest("could not get peer mailbox")
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.NewQueryResMailbox(peer, mailbox))
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func queryStatus(ctx sdk.Context, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	status := keeper.GetKernelStatus(ctx)
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResStatus{
+		Params:        keeper.GetParams(ctx),
+		Degraded:      status.Degraded,
+		DegradedSince: status.DegradedSince,
+	})
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func queryUsage(ctx sdk.Context, path []string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	var out types.QueryResUsage
+	if len(path) > 0 && path[0] != "" {
+		namespace := path[0]
+		quota, _ := keeper.GetParams(ctx).StorageQuota(namespace)
+		out.Namespaces = []types.NamespaceUsage{
+			types.NewNamespaceUsage(namespace, keeper.GetStorageUsage(ctx, namespace), quota),
+		}
+	} else {
+		out.Namespaces = keeper.GetAllStorageUsage(ctx)
+	}
+
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, out)
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func queryPeers(ctx sdk.Context, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	var params types.QueryPeersParams
+	if len(req.Data) != 0 {
+		if err2 := keeper.cdc.UnmarshalJSON(req.Data, &params); err2 != nil {
+			return []byte{}, sdk.ErrUnknownRequest(fmt.Sprintf("incorrectly formatted request data: %s", err2))
+		}
+	}
+	peers, next := keeper.GetPeersPage(ctx, params.Start, types.PageLimit(params.Limit))
+	if peers == nil {
+		peers = []types.PeerInfo{}
+	}
+
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResPeers{Peers: peers, Next: next})
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func queryHas(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResHas{Has: keeper.HasStorage(ctx, path)})
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// pageParams reads the page that a keys, entries or values query asks for.
+func pageParams(req abci.RequestQuery, keeper Keeper) (types.QueryKeysParams, sdk.Error) {
+	var params types.QueryKeysParams
+	if len(req.Data) != 0 {
+		if err := keeper.cdc.UnmarshalJSON(req.Data, &params); err != nil {
+			return params, sdk.ErrUnknownRequest(fmt.Sprintf("incorrectly formatted request data: %s", err))
+		}
+	}
+	params.Limit = types.PageLimit(params.Limit)
+	return params, nil
+}
+
+// nolint: unparam
+func queryEntries(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	params, err := pageParams(req, keeper)
+	if err != nil {
+		return []byte{}, err
+	}
+	entries, next := keeper.GetEntriesPage(ctx, path, params.Start, params.Limit)
+	if entries == nil {
+		entries = []types.KeyValue{}
+	}
+
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResEntries{Entries: entries, Next: next})
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func queryValues(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	params, err := pageParams(req, keeper)
+	if err != nil {
+		return []byte{}, err
+	}
+	entries, next := keeper.GetEntriesPage(ctx, path, params.Start, params.Limit)
+	values := make([]*string, len(entries))
+	for i, entry := range entries {
+		values[i] = entry.Value
+	}
+
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResValues{Values: values, Next: next})
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func querySize(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	size := uint64(len(keeper.GetKeys(ctx, path).Keys))
+
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResSize{Size: size})
+	if err2 != nil {
+		panic("could not marshal result to JSON")
+	}
+
+	return bz, nil
+}
+
+// nolint: unparam
+func queryTree(ctx sdk.Context, path string, req abci.RequestQuery, keeper Keeper) (res []byte, err sdk.Error) {
+	params := types.NewQueryTreeParams(DefaultTreeDepth)
+	if len(req.Data) != 0 {
+		if err2 := keeper.cdc.UnmarshalJSON(req.Data, &params); err2 != nil {
+			return []byte{}, sdk.ErrUnknownRequest(fmt.Sprintf("incorrectly formatted request data: %s", err2))
+		}
+	}
+	if params.Depth < 0 || params.Depth > MaxTreeDepth {
+		return []byte{}, sdk.ErrUnknownRequest(fmt.Sprintf("depth must be from 0 to %d", MaxTreeDepth))
 	}
 
-	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResStorage{value})
+	bz, err2 := codec.MarshalJSONIndent(keeper.cdc, types.QueryResTree{Tree: keeper.GetTree(ctx, path, params.Depth, MaxTreeNodes)})
 	if err2 != nil {
 		panic("could not marshal result to JSON")
 	}
@@ -0,0 +1,191 @@
+package keeper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	abci "github.com/tendermint/tendermint/abci/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// query asks the querier at path, with params as the request data if given,
+// and decodes the answer into res.
+func query(t *testing.T, ctx sdk.Context, k Keeper, path string, params interface{}, res interface{}) {
+	t.Helper()
+	var req abci.RequestQuery
+	if params != nil {
+		bz, err := k.cdc.MarshalJSON(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Data = bz
+	}
+	bz, err := NewQuerier(k)(ctx, strings.Split(path, "/"), req)
+	if err != nil {
+		t.Fatalf("query %s: %s", path, err)
+	}
+	if err := k.cdc.UnmarshalJSON(bz, res); err != nil {
+		t.Fatalf("query %s answered %s: %s", path, bz, err)
+	}
+}
+
+func TestQueryPageLimits(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	n := types.MaxPageLimit + 1
+	for i := 0; i < n; i++ {
+		mustSet(t, ctx, k, fmt.Sprintf("data.k%04d", i), "v")
+	}
+
+	for _, tc := range []struct {
+		limit, want int
+	}{
+		// Asking for no limit gets the default page, not everything.
+		{0, types.DefaultPageLimit},
+		{-1, types.DefaultPageLimit},
+		{3, 3},
+		{types.MaxPageLimit, types.MaxPageLimit},
+		{n, types.MaxPageLimit},
+	} {
+		params := types.NewQueryKeysParams("", tc.limit)
+		var keys types.QueryResKeys
+		query(t, ctx, k, "keys/data", params, &keys)
+		if len(keys.Keys) != tc.want || keys.Next != fmt.Sprintf("k%04d", tc.want) {
+			t.Errorf("keys with limit %d = %d keys and next %q, want %d", tc.limit, len(keys.Keys), keys.Next, tc.want)
+		}
+		var entries types.QueryResEntries
+		query(t, ctx, k, "entries/data", params, &entries)
+		if len(entries.Entries) != tc.want {
+			t.Errorf("entries with limit %d = %d, want %d", tc.limit, len(entries.Entries), tc.want)
+		}
+		var values types.QueryResValues
+		query(t, ctx, k, "values/data", params, &values)
+		if len(values.Values) != tc.want {
+			t.Errorf("values with limit %d = %d, want %d", tc.limit, len(values.Values), tc.want)
+		}
+	}
+
+	// A query without request data gets the default page too.
+	var keys types.QueryResKeys
+	query(t, ctx, k, "keys/data", nil, &keys)
+	if len(keys.Keys) != types.DefaultPageLimit {
+		t.Errorf("keys without params = %d, want %d", len(keys.Keys), types.DefaultPageLimit)
+	}
+}
+
+func TestQueryPeersPageLimits(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	n := types.MaxPageLimit + 1
+	for i := 0; i < n; i++ {
+		if err := k.SetMailbox(ctx, fmt.Sprintf("peer%04d", i), types.NewMailbox()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit, want int
+	}{
+		{0, types.DefaultPageLimit},
+		{5, 5},
+		{n, types.MaxPageLimit},
+	} {
+		var peers types.QueryResPeers
+		query(t, ctx, k, "peers", types.NewQueryPeersParams("", tc.limit), &peers)
+		if len(peers.Peers) != tc.want || peers.Next != fmt.Sprintf("peer%04d", tc.want) {
+			t.Errorf("peers with limit %d = %d peers and next %q, want %d", tc.limit, len(peers.Peers), peers.Next, tc.want)
+		}
+	}
+}
+
+func TestQueryHasAndSize(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data.a", "1")
+	mustSet(t, ctx, k, "data.b", "")
+	mustSet(t, ctx, k, "data.c.d", "deep")
+
+	for _, tc := range []struct {
+		path string
+		has  bool
+		size uint64
+	}{
+		{"data.a", true, 0},
+		// An empty value is still a value.
+		{"data.b", true, 0},
+		// Only children with values are counted.
+		{"data", false, 2},
+		{"data.c", false, 1},
+		{"missing", false, 0},
+	} {
+		var has types.QueryResHas
+		query(t, ctx, k, "has/"+tc.path, nil, &has)
+		if has.Has != tc.has {
+			t.Errorf("has %s = %t, want %t", tc.path, has.Has, tc.has)
+		}
+		var size types.QueryResSize
+		query(t, ctx, k, "size/"+tc.path, nil, &size)
+		if size.Size != tc.size {
+			t.Errorf("size %s = %d, want %d", tc.path, size.Size, tc.size)
+		}
+	}
+}
+
+func TestQueryTree(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data", "top")
+	mustSet(t, ctx, k, "data.a", "1")
+	mustSet(t, ctx, k, "data.a.x", "2")
+	mustSet(t, ctx, k, "data.b", "3")
+
+	// By default, only the children, marked if they have children of their own.
+	var res types.QueryResTree
+	query(t, ctx, k, "tree/data", nil, &res)
+	tree := res.Tree
+	if tree.Path != "data" || tree.Value == nil || *tree.Value != "top" || len(tree.Children) != 2 {
+		t.Fatalf("tree data = %+v", tree)
+	}
+	if a := tree.Children[0]; a.Path != "data.a" || !a.Truncated || len(a.Children) != 0 {
+		t.Errorf("data.a at the default depth = %+v, want it truncated", a)
+	}
+	if b := tree.Children[1]; b.Path != "data.b" || b.Truncated {
+		t.Errorf("data.b = %+v, want it whole", b)
+	}
+
+	var deeper types.QueryResTree
+	query(t, ctx, k, "tree/data", types.NewQueryTreeParams(2), &deeper)
+	a := deeper.Tree.Children[0]
+	if a.Truncated || len(a.Children) != 1 || *a.Children[0].Value != "2" {
+		t.Errorf("data.a at depth 2 = %+v", a)
+	}
+
+	var root types.QueryResTree
+	query(t, ctx, k, "tree/data", types.NewQueryTreeParams(0), &root)
+	if !root.Tree.Truncated || len(root.Tree.Children) != 0 {
+		t.Errorf("tree at depth 0 = %+v, want only the root", root.Tree)
+	}
+
+	for _, depth := range []int{-1, MaxTreeDepth + 1} {
+		bz, err := k.cdc.MarshalJSON(types.NewQueryTreeParams(depth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewQuerier(k)(ctx, []string{QueryTree, "data"}, abci.RequestQuery{Data: bz}); err == nil {
+			t.Errorf("tree at depth %d succeeded", depth)
+		}
+	}
+}
+
+func TestQueryTreeIsTruncatedAtMaxNodes(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	for i := 0; i < MaxTreeNodes; i++ {
+		mustSet(t, ctx, k, fmt.Sprintf("data.k%04d", i), "v")
+	}
+	var res types.QueryResTree
+	query(t, ctx, k, "tree/data", nil, &res)
+	// The root is one of the nodes.
+	if !res.Tree.Truncated || len(res.Tree.Children) != MaxTreeNodes-1 {
+		t.Errorf("tree of %d children has %d, truncated %t; want %d, truncated",
+			MaxTreeNodes, len(res.Tree.Children), res.Tree.Truncated, MaxTreeNodes-1)
+	}
+}
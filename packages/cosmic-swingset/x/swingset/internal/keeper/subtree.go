@@ -0,0 +1,160 @@
+package keeper
+
+import (
+	"fmt"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// Within says whether path is root or under it, where every path is under
+// the empty root.
+func Within(path, root string) bool {
+	return root == "" || path == root || strings.HasPrefix(path, root+".")
+}
+
+// GetSubtree returns the entries at and under path, in order.  An empty path
+// means all of storage.
+func (k Keeper) GetSubtree(ctx sdk.Context, path string) []types.StorageEntry {
+	var entries []types.StorageEntry
+	prefix := StorageKey("")
+	if path != "" {
+		if k.HasStorage(ctx, path) {
+			entries = append(entries, types.StorageEntry{Path: path, Value: k.GetStorage(ctx, path).Value})
+		}
+		prefix = StorageKey(path + ".")
+	}
+
+	store := ctx.KVStore(k.storeKey)
+	iterator := sdk.KVStorePrefixIterator(store, prefix)
+	defer iterator.Close()
+	for ; iterator.Valid(); iterator.Next() {
+		entryPath := string(iterator.Key()[len(dataPrefix):])
+		entries = append(entries, types.StorageEntry{Path: entryPath, Value: k.unmarshalValue(entryPath, iterator.Value())})
+	}
+	return entries
+}
+
+// GetTree returns the value at path and the trees of its children, down to
+// depth levels of children and with no more than maxNodes nodes in all.  An
+// empty path means all of storage.
+func (k Keeper) GetTree(ctx sdk.Context, path string, depth, maxNodes int) types.StorageTree {
+	nodes := maxNodes - 1
+	return k.getTree(ctx, path, depth, &nodes)
+}
+
+// getTree is GetTree, taking the nodes it adds below path from *nodes.
+func (k Keeper) getTree(ctx sdk.Context, path string, depth int, nodes *int) types.StorageTree {
+	tree := types.StorageTree{Path: path}
+	if path != "" && k.HasStorage(ctx, path) {
+		value := k.GetStorage(ctx, path).Value
+		tree.Value = &value
+	}
+	if depth <= 0 {
+		tree.Truncated = hasChildren(ctx.KVStore(k.storeKey), path)
+		return tree
+	}
+	for _, key := range k.GetKeys(ctx, path).Keys {
+		if *nodes <= 0 {
+			tree.Truncated = true
+			break
+		}
+		*nodes--
+		tree.Children = append(tree.Children, k.getTree(ctx, childPath(path, key), depth-1, nodes))
+	}
+	return tree
+}
+
+// DeleteSubtree deletes the values at and under path.
+func (k Keeper) DeleteSubtree(ctx sdk.Context, path string) ([]types.StorageChange, sdk.Error) {
+	if path == "" {
+		return nil, types.ErrInvalidPath(k.codespace, "cannot delete all of storage")
+	}
+
+	entries := k.GetSubtree(ctx, path)
+	var deltas usageDeltas
+	for _, e := range entries {
+		deltas.change(e.Path, e.Value, -1)
+	}
+	// Shrinking is never over quota.
+	_ = k.updateStorageUsage(ctx, deltas)
+
+	changes := make([]types.StorageChange, len(entries))
+	for i, e := range entries {
+		k.removeStorage(ctx, e.Path)
+		changes[i] = types.StorageChange{Path: e.Path, Deleted: true}
+	}
+	return changes, nil
+}
+
+// CopySubtree makes the values at and under to a copy of those at and under
+// from, unless that would put to's namespace over quota.
+func (k Keeper) CopySubtree(ctx sdk.Context, from, to string) ([]types.StorageChange, sdk.Error) {
+	return k.copySubtree(ctx, from, to, false)
+}
+
+// MoveSubtree is CopySubtree, but then deletes the values at and under from.
+func (k Keeper) MoveSubtree(ctx sdk.Context, from, to string) ([]types.StorageChange, sdk.Error) {
+	return k.copySubtree(ctx, from, to, true)
+}
+
+func (k Keeper) copySubtree(ctx sdk.Context, from, to string, move bool) ([]types.StorageChange, sdk.Error) {
+	if from == "" || to == "" {
+		return nil, types.ErrInvalidPath(k.codespace, "cannot copy all of storage")
+	}
+	if Within(from, to) || Within(to, from) {
+		return nil, types.ErrInvalidPath(k.codespace, fmt.Sprintf("%s and %s overlap", from, to))
+	}
+
+	sources := k.GetSubtree(ctx, from)
+	targets := make([]types.StorageEntry, len(sources))
+	copied := make(map[string]bool, len(sources))
+	for i, e := range sources {
+		path := to + e.Path[len(from):]
+		value, err := k.canonicalValue(path, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = types.StorageEntry{Path: path, Value: value}
+		copied[path] = true
+	}
+	replaced := k.GetSubtree(ctx, to)
+
+	// Check the quotas before changing anything.
+	var deltas usageDeltas
+	for _, e := range replaced {
+		deltas.change(e.Path, e.Value, -1)
+	}
+	for _, e := range targets {
+		deltas.change(e.Path, e.Value, 1)
+	}
+	if move {
+		for _, e := range sources {
+			deltas.change(e.Path, e.Value, -1)
+		}
+	}
+	if err := k.updateStorageUsage(ctx, deltas); err != nil {
+		return nil, err
+	}
+
+	var changes []types.StorageChange
+	for _, e := range replaced {
+		if !copied[e.Path] {
+			k.removeStorage(ctx, e.Path)
+			changes = append(changes, types.StorageChange{Path: e.Path, Deleted: true})
+		}
+	}
+	for _, e := range targets {
+		k.writeStorage(ctx, e.Path, types.Storage{Value: e.Value})
+		changes = append(changes, types.StorageChange{Path: e.Path, Value: e.Value})
+	}
+	if move {
+		for _, e := range sources {
+			k.removeStorage(ctx, e.Path)
+			changes = append(changes, types.StorageChange{Path: e.Path, Deleted: true})
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,173 @@
+package keeper
+
+import (
+	"reflect"
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// checkKeys fails the test unless the child index of path lists want, which
+// are the children of path that have values.
+func checkKeys(t *testing.T, ctx sdk.Context, k Keeper, path string, want ...string) {
+	t.Helper()
+	got := k.GetKeys(ctx, path).Keys
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keys of %q = %q, want %q", path, got, want)
+	}
+}
+
+func TestDeleteSubtreeMaintainsChildIndex(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data.x", "root")
+	mustSet(t, ctx, k, "data.x.a", "1")
+	mustSet(t, ctx, k, "data.x.b.c", "22")
+	mustSet(t, ctx, k, "data.y", "kept")
+	mustSet(t, ctx, k, "lone.p.q", "only child")
+
+	checkKeys(t, ctx, k, "data", "x", "y")
+	checkKeys(t, ctx, k, "data.x", "a")
+	checkKeys(t, ctx, k, "data.x.b", "c")
+
+	changes, err := k.DeleteSubtree(ctx, "data.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("DeleteSubtree reported %d changes, want 3", len(changes))
+	}
+	for _, path := range []string{"data.x", "data.x.a", "data.x.b.c"} {
+		if k.HasStorage(ctx, path) {
+			t.Errorf("%s survived DeleteSubtree", path)
+		}
+	}
+	checkKeys(t, ctx, k, "data", "y")
+	checkKeys(t, ctx, k, "data.x")
+	checkKeys(t, ctx, k, "data.x.b")
+
+	// Deleting under a path without a value of its own
+	checkKeys(t, ctx, k, "lone.p", "q")
+	if _, err := k.DeleteSubtree(ctx, "lone.p"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, ctx, k, "lone.p")
+	checkUsage(t, ctx, k)
+	checkInvariants(t, ctx, k)
+
+	if _, err := k.DeleteSubtree(ctx, ""); err == nil {
+		t.Error("deleted all of storage")
+	}
+}
+
+func TestCopyAndMoveSubtreeMaintainChildIndex(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data.x", "root")
+	mustSet(t, ctx, k, "data.x.a", "1")
+	mustSet(t, ctx, k, "data.x.b.c", "22")
+	mustSet(t, ctx, k, "copy.y.stale", "replaced")
+
+	if _, err := k.CopySubtree(ctx, "data.x", "copy.y"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, ctx, k, "copy", "y")
+	checkKeys(t, ctx, k, "copy.y", "a")
+	checkKeys(t, ctx, k, "copy.y.b", "c")
+	// The source is untouched.
+	checkKeys(t, ctx, k, "data", "x")
+	checkKeys(t, ctx, k, "data.x", "a")
+	checkInvariants(t, ctx, k)
+
+	if _, err := k.MoveSubtree(ctx, "data.x", "moved.z"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, ctx, k, "moved", "z")
+	checkKeys(t, ctx, k, "moved.z", "a")
+	checkKeys(t, ctx, k, "moved.z.b", "c")
+	checkKeys(t, ctx, k, "data")
+	checkKeys(t, ctx, k, "data.x.b")
+	if got := k.GetStorage(ctx, "moved.z.b.c").Value; got != "22" {
+		t.Errorf("moved value = %q, want %q", got, "22")
+	}
+	checkUsage(t, ctx, k)
+	checkInvariants(t, ctx, k)
+
+	for _, paths := range [][2]string{{"copy", "copy.y.a"}, {"copy.y", "copy"}, {"", "x"}} {
+		if _, err := k.CopySubtree(ctx, paths[0], paths[1]); err == nil {
+			t.Errorf("copied %q to %q", paths[0], paths[1])
+		}
+	}
+}
+
+func TestStorageUsageOfSubtrees(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data.x", "root")
+	mustSet(t, ctx, k, "data.x.a", "1")
+	mustSet(t, ctx, k, "data.x.b.c", "22")
+	mustSet(t, ctx, k, "copy.y.stale", "replaced")
+
+	if _, err := k.CopySubtree(ctx, "data.x", "copy.y"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, ctx, k)
+	if k.HasStorage(ctx, "copy.y.stale") {
+		t.Error("copying kept a replaced entry")
+	}
+	if got := k.GetStorageUsage(ctx, "copy").Entries; got != 3 {
+		t.Errorf("copy has %d entries, want 3", got)
+	}
+
+	// Moving within a namespace changes only the lengths of the paths.
+	if _, err := k.MoveSubtree(ctx, "data.x", "data.moved"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, ctx, k)
+
+	// Moving across namespaces takes the usage with it.
+	if _, err := k.MoveSubtree(ctx, "data.moved", "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, ctx, k)
+	if got := k.GetStorageUsage(ctx, "data"); got != (types.StorageUsage{}) {
+		t.Errorf("usage of data after moving it all = %+v", got)
+	}
+
+	if _, err := k.DeleteSubtree(ctx, "copy.y"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, ctx, k)
+	checkInvariants(t, ctx, k)
+}
+
+func TestStorageQuotaRefusesSubtrees(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data.x.a", "1")
+	mustSet(t, ctx, k, "data.x.b", "2")
+	mustSet(t, ctx, k, "full.y", "3")
+	setQuotas(ctx, k, types.StorageQuota{Namespace: "full", MaxEntries: 2})
+
+	for name, op := range map[string]func() ([]types.StorageChange, sdk.Error){
+		"CopySubtree": func() ([]types.StorageChange, sdk.Error) { return k.CopySubtree(ctx, "data.x", "full.x") },
+		"MoveSubtree": func() ([]types.StorageChange, sdk.Error) { return k.MoveSubtree(ctx, "data.x", "full.x") },
+	} {
+		if _, err := op(); !isQuotaExceeded(err) {
+			t.Errorf("%s over quota = %v, want a quota error", name, err)
+		}
+		if k.HasStorage(ctx, "full.x.a") || !k.HasStorage(ctx, "data.x.a") {
+			t.Errorf("%s over quota changed storage", name)
+		}
+		checkUsage(t, ctx, k)
+	}
+
+	// Replacing entries makes room for their copies.
+	mustSet(t, ctx, k, "full.x.old", "0")
+	if _, err := k.CopySubtree(ctx, "data.x.a", "full.x"); err != nil {
+		t.Errorf("CopySubtree replacing as many entries: %s", err)
+	}
+	checkUsage(t, ctx, k)
+	checkInvariants(t, ctx, k)
+}
@@ -0,0 +1,136 @@
+package keeper
+
+import (
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// Namespace returns the top-level segment of path, which quotas apply to.
+func Namespace(path string) string {
+	return strings.SplitN(path, ".", 2)[0]
+}
+
+// The storage an entry uses.
+func entrySize(path, value string) uint64 {
+	return uint64(len(path) + len(value))
+}
+
+// Gets how much storage a namespace uses
+func (k Keeper) GetStorageUsage(ctx sdk.Context, namespace string) types.StorageUsage {
+	store := ctx.KVStore(k.storeKey)
+	var usage types.StorageUsage
+	bz := store.Get([]byte(usagePrefix + namespace))
+	if bz != nil {
+		k.cdc.MustUnmarshalBinaryBare(bz, &usage)
+	}
+	return usage
+}
+
+// Sets how much storage a namespace uses
+func (k Keeper) setStorageUsage(ctx sdk.Context, namespace string, usage types.StorageUsage) {
+	store := ctx.KVStore(k.storeKey)
+	if usage == (types.StorageUsage{}) {
+		store.Delete([]byte(usagePrefix + namespace))
+		return
+	}
+	store.Set([]byte(usagePrefix+namespace), k.cdc.MustMarshalBinaryBare(usage))
+}
+
+// Gets how much storage each namespace uses, with its quota, in order
+func (k Keeper) GetAllStorageUsage(ctx sdk.Context) []types.NamespaceUsage {
+	params := k.GetParams(ctx)
+	store := ctx.KVStore(k.storeKey)
+	iterator := sdk.KVStorePrefixIterator(store, []byte(usagePrefix))
+	defer iterator.Close()
+
+	all := []types.NamespaceUsage{}
+	for ; iterator.Valid(); iterator.Next() {
+		namespace := string(iterator.Key()[len(usagePrefix):])
+		var usage types.StorageUsage
+		k.cdc.MustUnmarshalBinaryBare(iterator.Value(), &usage)
+		quota, _ := params.StorageQuota(namespace)
+		all = append(all, types.NewNamespaceUsage(namespace, usage, quota))
+	}
+	return all
+}
+
+// usageDeltas are changes to the storage that namespaces use, in the order
+// the namespaces were first changed.
+type usageDeltas []usageDelta
+
+type usageDelta struct {
+	namespace string
+	bytes     int64
+	entries   int64
+}
+
+// change accounts for the entry at path being added (sign 1) or removed
+// (sign -1).
+func (ds *usageDeltas) change(path, value string, sign int64) {
+	namespace := Namespace(path)
+	size := sign * int64(entrySize(path, value))
+	for i := range *ds {
+		if (*ds)[i].namespace == namespace {
+			(*ds)[i].bytes += size
+			(*ds)[i].entries += sign
+			return
+		}
+	}
+	*ds = append(*ds, usageDelta{namespace: namespace, bytes: size, entries: sign})
+}
+
+// updateStorageUsage applies deltas, unless that would put a namespace over
+// quota, in which case none are applied.
+func (k Keeper) updateStorageUsage(ctx sdk.Context, deltas usageDeltas) sdk.Error {
+	params := k.GetParams(ctx)
+	afters := make([]types.StorageUsage, len(deltas))
+	for i, delta := range deltas {
+		before := k.GetStorageUsage(ctx, delta.namespace)
+		after := types.StorageUsage{
+			Bytes:   uint64(int64(before.Bytes) + delta.bytes),
+			Entries: uint64(int64(before.Entries) + delta.entries),
+		}
+		if quota, ok := params.StorageQuota(delta.namespace); ok {
+			if msg := quota.Exceeded(before, after); msg != "" {
+				return types.ErrQuotaExceeded(k.codespace, msg)
+			}
+		}
+		afters[i] = after
+	}
+
+	for i, delta := range deltas {
+		k.setStorageUsage(ctx, delta.namespace, afters[i])
+	}
+	return nil
+}
+
+// migrateStorageUsage adds up the storage that each namespace already uses.
+func (k Keeper) migrateStorageUsage(ctx sdk.Context) {
+	store := ctx.KVStore(k.storeKey)
+	usages := make(map[string]types.StorageUsage)
+	var namespaces []string
+
+	iterator := sdk.KVStorePrefixIterator(store, []byte(dataPrefix))
+	for ; iterator.Valid(); iterator.Next() {
+		path := string(iterator.Key()[len(dataPrefix):])
+		var storage types.Storage
+		k.cdc.MustUnmarshalBinaryBare(iterator.Value(), &storage)
+
+		namespace := Namespace(path)
+		usage, ok := usages[namespace]
+		if !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		usage.Bytes += entrySize(path, storage.Value)
+		usage.Entries++
+		usages[namespace] = usage
+	}
+	iterator.Close()
+
+	for _, namespace := range namespaces {
+		k.setStorageUsage(ctx, namespace, usages[namespace])
+	}
+}
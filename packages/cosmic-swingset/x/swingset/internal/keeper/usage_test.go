@@ -0,0 +1,107 @@
+package keeper
+
+import (
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/internal/types"
+)
+
+// checkUsage fails the test unless the usage of every namespace is what its
+// entries add up to.
+func checkUsage(t *testing.T, ctx sdk.Context, k Keeper) {
+	t.Helper()
+	want := map[string]types.StorageUsage{}
+	for _, e := range k.GetSubtree(ctx, "") {
+		u := want[Namespace(e.Path)]
+		u.Bytes += entrySize(e.Path, e.Value)
+		u.Entries++
+		want[Namespace(e.Path)] = u
+	}
+	all := k.GetAllStorageUsage(ctx)
+	if len(all) != len(want) {
+		t.Errorf("usage is kept for %d namespaces, want %d", len(all), len(want))
+	}
+	for _, nu := range all {
+		if got := k.GetStorageUsage(ctx, nu.Namespace); got != want[nu.Namespace] {
+			t.Errorf("usage of %s = %+v, want %+v", nu.Namespace, got, want[nu.Namespace])
+		}
+	}
+}
+
+// setQuotas sets the storage quotas, keeping the other parameters.
+func setQuotas(ctx sdk.Context, k Keeper, quotas ...types.StorageQuota) {
+	params := k.GetParams(ctx)
+	params.StorageQuotas = quotas
+	k.SetParams(ctx, params)
+}
+
+func mustSet(t *testing.T, ctx sdk.Context, k Keeper, path, value string) {
+	t.Helper()
+	if err := k.SetStorage(ctx, path, types.Storage{Value: value}); err != nil {
+		t.Fatalf("SetStorage(%s): %s", path, err)
+	}
+}
+
+func isQuotaExceeded(err sdk.Error) bool {
+	return err != nil && err.Code() == types.CodeQuotaExceeded
+}
+
+func TestStorageUsageOfSetAndDelete(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+
+	mustSet(t, ctx, k, "data.a", "1")
+	mustSet(t, ctx, k, "data.b", "")
+	mustSet(t, ctx, k, "other.c", "three")
+	checkUsage(t, ctx, k)
+	if got, want := k.GetStorageUsage(ctx, "data"), (types.StorageUsage{
+		Bytes: entrySize("data.a", "1") + entrySize("data.b", ""), Entries: 2,
+	}); got != want {
+		t.Errorf("usage of data = %+v, want %+v", got, want)
+	}
+
+	// Overwriting changes the size, not the count.
+	mustSet(t, ctx, k, "data.a", "a longer value")
+	mustSet(t, ctx, k, "data.b", "")
+	checkUsage(t, ctx, k)
+
+	k.DeleteStorage(ctx, "data.a")
+	k.DeleteStorage(ctx, "data.missing")
+	checkUsage(t, ctx, k)
+
+	// A namespace with nothing left has no usage.
+	k.DeleteStorage(ctx, "data.b")
+	checkUsage(t, ctx, k)
+	if got := k.GetStorageUsage(ctx, "data"); got != (types.StorageUsage{}) {
+		t.Errorf("usage of emptied data = %+v", got)
+	}
+}
+
+func TestStorageQuotaRefusesGrowth(t *testing.T) {
+	ctx, k := initTestKeeper(t)
+	mustSet(t, ctx, k, "data.a", "12345")
+	limit := k.GetStorageUsage(ctx, "data").Bytes + entrySize("data.b", "x")
+	setQuotas(ctx, k, types.StorageQuota{Namespace: "data", MaxBytes: limit})
+
+	mustSet(t, ctx, k, "data.b", "x")
+	before := k.GetStorageUsage(ctx, "data")
+	if err := k.SetStorage(ctx, "data.c", types.Storage{Value: "y"}); !isQuotaExceeded(err) {
+		t.Errorf("SetStorage over quota = %v, want a quota error", err)
+	}
+	if k.HasStorage(ctx, "data.c") {
+		t.Error("a write over quota was stored")
+	}
+	if got := k.GetStorageUsage(ctx, "data"); got != before {
+		t.Errorf("usage after a refused write = %+v, want %+v", got, before)
+	}
+
+	// Other namespaces have no quota.
+	mustSet(t, ctx, k, "other.c", "a value of any size")
+
+	// Shrinking is allowed even over quota.
+	setQuotas(ctx, k, types.StorageQuota{Namespace: "data", MaxBytes: 1})
+	mustSet(t, ctx, k, "data.a", "1")
+	k.DeleteStorage(ctx, "data.b")
+	checkUsage(t, ctx, k)
+}
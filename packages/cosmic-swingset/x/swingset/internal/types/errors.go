@@ -0,0 +1,61 @@
+package types
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// SwingSet errors reserve 100 ~ 199.
+const (
+	DefaultCodespace sdk.CodespaceType = ModuleName
+
+	CodeMalformedMessage      sdk.CodeType = 101
+	CodeKernelFailed          sdk.CodeType = 102
+	CodeResourceExhausted     sdk.CodeType = 103
+	CodeKernelDegraded        sdk.CodeType = 104
+	CodeControllerUnavailable sdk.CodeType = 105
+	CodeQuotaExceeded         sdk.CodeType = 106
+	CodeInvalidPath           sdk.CodeType = 107
+	CodeInvalidMailbox        sdk.CodeType = 108
+)
+
+// ErrMalformedMessage is an error
+func ErrMalformedMessage(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeMalformedMessage, "%s", msg)
+}
+
+// ErrKernelFailed is an error
+func ErrKernelFailed(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeKernelFailed, "%s", msg)
+}
+
+// ErrResourceExhausted is an error
+func ErrResourceExhausted(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeResourceExhausted, "%s", msg)
+}
+
+// ErrKernelDegraded is an error
+func ErrKernelDegraded(codespace sdk.CodespaceType, since int64) sdk.Error {
+	return sdk.NewError(codespace, CodeKernelDegraded,
+		"kernel degraded since height %d; refusing deliveries", since)
+}
+
+// ErrControllerUnavailable is an error that halts the node, so it is never
+// the result of a committed tx
+func ErrControllerUnavailable(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeControllerUnavailable, "%s", msg)
+}
+
+// ErrQuotaExceeded is an error
+func ErrQuotaExceeded(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeQuotaExceeded, "%s", msg)
+}
+
+// ErrInvalidPath is an error
+func ErrInvalidPath(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeInvalidPath, "%s", msg)
+}
+
+// ErrInvalidMailbox is an error
+func ErrInvalidMailbox(codespace sdk.CodespaceType, msg string) sdk.Error {
+	return sdk.NewError(codespace, CodeInvalidMailbox, "%s", msg)
+}
@@ -0,0 +1,20 @@
+package types
+
+// SwingSet module event types
+var (
+	EventTypeBeginBlockFailed = "begin_block_failed"
+	EventTypeKernelDegraded   = "kernel_degraded"
+	EventTypeKernelRecovered  = "kernel_recovered"
+	EventTypeStorage          = "storage"
+
+	AttributeKeyPolicy        = "policy"
+	AttributeKeyAttempts      = "attempts"
+	AttributeKeyCorrelationID = "correlation_id"
+	AttributeKeyHeight        = "height"
+	AttributeKeyPath          = "path"
+	AttributeKeyValue         = "value"
+	AttributeKeyValueHash     = "value_hash"
+	AttributeKeyDeleted       = "deleted"
+
+	AttributeValueCategory = ModuleName
+)
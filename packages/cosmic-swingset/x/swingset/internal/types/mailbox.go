@@ -0,0 +1,138 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OutboxMessage is a numbered message waiting for a peer to acknowledge it.
+type OutboxMessage struct {
+	Num  uint64 `json:"num" yaml:"num"`
+	Body string `json:"body" yaml:"body"`
+}
+
+// Mailbox is what the chain has for a peer: the messages it has not yet
+// acknowledged, and the number of the last message from it that the chain
+// has.
+type Mailbox struct {
+	Outbox []OutboxMessage `json:"outbox" yaml:"outbox"`
+	Ack    uint64          `json:"ack" yaml:"ack"`
+}
+
+// Returns a new empty Mailbox
+func NewMailbox() Mailbox {
+	return Mailbox{Outbox: []OutboxMessage{}}
+}
+
+// Validate checks that the outbox is in order.
+func (m Mailbox) Validate() error {
+	var last uint64
+	for i, msg := range m.Outbox {
+		if msg.Num == 0 {
+			return fmt.Errorf("outbox message %d has no num", i)
+		}
+		if msg.Num <= last {
+			return fmt.Errorf("outbox message %d has num %d, after %d", i, msg.Num, last)
+		}
+		last = msg.Num
+	}
+	return nil
+}
+
+// The kernel's JSON for a mailbox, with each message as a [num, body] pair.
+// The kernel writes it with deterministic-json, which sorts the keys.
+type kernelMailbox struct {
+	Ack    uint64              `json:"ack"`
+	Outbox [][]json.RawMessage `json:"outbox"`
+}
+
+// ParseMailboxValue reads a mailbox as the kernel stores it, which is its JSON
+// encoded again as a JSON string, and validates it.
+func ParseMailboxValue(value string) (Mailbox, error) {
+	var data string
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return Mailbox{}, fmt.Errorf("mailbox is not a JSON string: %s", err)
+	}
+	var km kernelMailbox
+	if err := json.Unmarshal([]byte(data), &km); err != nil {
+		return Mailbox{}, fmt.Errorf("malformed mailbox: %s", err)
+	}
+
+	mailbox := Mailbox{Outbox: make([]OutboxMessage, len(km.Outbox)), Ack: km.Ack}
+	for i, pair := range km.Outbox {
+		if len(pair) != 2 {
+			return Mailbox{}, fmt.Errorf("outbox message %d is not a [num, body] pair", i)
+		}
+		if err := json.Unmarshal(pair[0], &mailbox.Outbox[i].Num); err != nil {
+			return Mailbox{}, fmt.Errorf("outbox message %d num: %s", i, err)
+		}
+		if err := json.Unmarshal(pair[1], &mailbox.Outbox[i].Body); err != nil {
+			return Mailbox{}, fmt.Errorf("outbox message %d body: %s", i, err)
+		}
+	}
+	if err := mailbox.Validate(); err != nil {
+		return Mailbox{}, err
+	}
+	return mailbox, nil
+}
+
+// KernelValue returns the mailbox as the kernel stores it: deterministic-json
+// of its {ack, outbox}, encoded again as a JSON string, both by
+// JSON.stringify.
+func (m Mailbox) KernelValue() string {
+	var data strings.Builder
+	fmt.Fprintf(&data, `{"ack":%d,"outbox":[`, m.Ack)
+	for i, msg := range m.Outbox {
+		if i > 0 {
+			data.WriteByte(',')
+		}
+		fmt.Fprintf(&data, "[%d,%s]", msg.Num, quoteJS(msg.Body))
+	}
+	data.WriteString("]}")
+	return quoteJS(data.String())
+}
+
+// quoteJS quotes s as JSON.stringify does, which unlike encoding/json leaves
+// HTML and U+2028 and U+2029 alone, and has short escapes for \b and \f.
+// Invalid UTF-8 becomes U+FFFD, so a value holding it never reads back the
+// same.
+func quoteJS(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// implement fmt.Stringer
+func (m Mailbox) String() string {
+	lines := []string{fmt.Sprintf("ack: %d", m.Ack)}
+	for _, msg := range m.Outbox {
+		lines = append(lines, fmt.Sprintf("%d: %s", msg.Num, msg.Body))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -37,21 +37,21 @@ func (msg MsgDeliverInbound) ValidateBasic() sdk.Error {
 		return sdk.ErrInvalidAddress(msg.Submitter.String())
 	}
 	if len(msg.Peer) == 0 {
-		return sdk.ErrUnknownRequest("Peer cannot be empty")
+		return ErrMalformedMessage(DefaultCodespace, "Peer cannot be empty")
 	}
 	if len(msg.Messages) != len(msg.Nums) {
-		return sdk.ErrUnknownRequest("Messages and Nums must be the same length")
+		return ErrMalformedMessage(DefaultCodespace, "Messages and Nums must be the same length")
 	}
 	for i, num := range msg.Nums {
 		if len(msg.Messages[i]) == 0 {
-			return sdk.ErrUnknownRequest("Messages cannot be empty")
+			return ErrMalformedMessage(DefaultCodespace, "Messages cannot be empty")
 		}
 		if num < 0 {
-			return sdk.ErrUnknownRequest("Nums cannot be negative")
+			return ErrMalformedMessage(DefaultCodespace, "Nums cannot be negative")
 		}
 	}
 	if msg.Ack < 0 {
-		return sdk.ErrUnknownRequest("Ack cannot be negative")
+		return ErrMalformedMessage(DefaultCodespace, "Ack cannot be negative")
 	}
 	return nil
 }
@@ -0,0 +1,185 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/x/params"
+)
+
+// Default parameter namespace
+const (
+	DefaultParamspace = ModuleName
+)
+
+// What to do when the kernel rejects a BEGIN_BLOCK.  Failures that aren't
+// the kernel's rejection always halt.
+const (
+	// Halt the node, leaving a diagnostic dump in its log.
+	PolicyHalt = "halt"
+	// Retry up to MaxRetries times, waiting RetryBackoff before the first
+	// retry and twice as long before each one after, and halt if it still
+	// fails.
+	PolicyRetry = "retry"
+	// Carry on, but refuse deliveries until a BEGIN_BLOCK succeeds.
+	PolicyDegrade = "degrade"
+)
+
+// Default parameters
+const (
+	DefaultBeginBlockFailurePolicy = PolicyHalt
+	DefaultMaxRetries              = uint16(3)
+	DefaultRetryBackoff            = time.Second
+	// Enough for BEGIN_BLOCK to write about 3MB of storage.
+	DefaultBeginBlockGasLimit = uint64(100000000)
+)
+
+// DefaultStorageGas charges what the Cosmos KVStore does.
+var DefaultStorageGas = GasSchedule{
+	ReadCostFlat:     1000,
+	ReadCostPerByte:  3,
+	WriteCostFlat:    2000,
+	WriteCostPerByte: 30,
+}
+
+// Parameter store keys
+var (
+	KeyBeginBlockFailurePolicy = []byte("BeginBlockFailurePolicy")
+	KeyMaxRetries              = []byte("MaxRetries")
+	KeyRetryBackoff            = []byte("RetryBackoff")
+	KeyStorageGas              = []byte("StorageGas")
+	KeyBeginBlockGasLimit      = []byte("BeginBlockGasLimit")
+	KeyStorageQuotas           = []byte("StorageQuotas")
+)
+
+// ParamKeyTable for swingset module
+func ParamKeyTable() params.KeyTable {
+	return params.NewKeyTable().RegisterParamSet(&Params{})
+}
+
+// GasSchedule is what the kernel's storage operations cost.  Each one costs
+// a flat amount, plus an amount for each byte of its key, value and result.
+// A set is a write, and everything else is a read.
+type GasSchedule struct {
+	ReadCostFlat     uint64 `json:"read_cost_flat" yaml:"read_cost_flat"`
+	ReadCostPerByte  uint64 `json:"read_cost_per_byte" yaml:"read_cost_per_byte"`
+	WriteCostFlat    uint64 `json:"write_cost_flat" yaml:"write_cost_flat"`
+	WriteCostPerByte uint64 `json:"write_cost_per_byte" yaml:"write_cost_per_byte"`
+}
+
+// StorageQuota bounds the storage under one top-level path segment, such as
+// "mailbox".  A zero maximum means no limit.
+type StorageQuota struct {
+	Namespace  string `json:"namespace" yaml:"namespace"`
+	MaxBytes   uint64 `json:"max_bytes" yaml:"max_bytes"`
+	MaxEntries uint64 `json:"max_entries" yaml:"max_entries"`
+}
+
+// Exceeded says how after goes over the quota where it has grown from
+// before, or "" if it doesn't.  Shrinking is always allowed.
+func (q StorageQuota) Exceeded(before, after StorageUsage) string {
+	if q.MaxBytes > 0 && after.Bytes > q.MaxBytes && after.Bytes > before.Bytes {
+		return fmt.Sprintf("%s would use %d bytes, over its quota of %d", q.Namespace, after.Bytes, q.MaxBytes)
+	}
+	if q.MaxEntries > 0 && after.Entries > q.MaxEntries && after.Entries > before.Entries {
+		return fmt.Sprintf("%s would have %d entries, over its quota of %d", q.Namespace, after.Entries, q.MaxEntries)
+	}
+	return ""
+}
+
+// Params - used for initializing default parameter for swingset at genesis
+type Params struct {
+	BeginBlockFailurePolicy string        `json:"begin_block_failure_policy" yaml:"begin_block_failure_policy"`
+	MaxRetries              uint16        `json:"max_retries" yaml:"max_retries"`
+	RetryBackoff            time.Duration `json:"retry_backoff" yaml:"retry_backoff"`
+	StorageGas              GasSchedule   `json:"storage_gas" yaml:"storage_gas"`
+	// The gas a BEGIN_BLOCK may use for storage, or zero for no limit.
+	// Running out fails the BEGIN_BLOCK as BeginBlockFailurePolicy says.
+	BeginBlockGasLimit uint64 `json:"begin_block_gas_limit" yaml:"begin_block_gas_limit"`
+	// Namespaces without a quota are unlimited.
+	StorageQuotas []StorageQuota `json:"storage_quotas" yaml:"storage_quotas"`
+}
+
+// NewParams creates a new Params object
+func NewParams(beginBlockFailurePolicy string, maxRetries uint16, retryBackoff time.Duration,
+	storageGas GasSchedule, beginBlockGasLimit uint64, storageQuotas []StorageQuota) Params {
+	return Params{
+		BeginBlockFailurePolicy: beginBlockFailurePolicy,
+		MaxRetries:              maxRetries,
+		RetryBackoff:            retryBackoff,
+		StorageGas:              storageGas,
+		BeginBlockGasLimit:      beginBlockGasLimit,
+		StorageQuotas:           storageQuotas,
+	}
+}
+
+func (p Params) String() string {
+	return fmt.Sprintf(`SwingSet Params:
+  BeginBlockFailurePolicy: %s
+  MaxRetries:              %d
+  RetryBackoff:            %s
+  StorageGas:
+    ReadCostFlat:          %d
+    ReadCostPerByte:       %d
+    WriteCostFlat:         %d
+    WriteCostPerByte:      %d
+  BeginBlockGasLimit:      %d
+  StorageQuotas:           %v`, p.BeginBlockFailurePolicy,
+		p.MaxRetries, p.RetryBackoff,
+		p.StorageGas.ReadCostFlat, p.StorageGas.ReadCostPerByte,
+		p.StorageGas.WriteCostFlat, p.StorageGas.WriteCostPerByte,
+		p.BeginBlockGasLimit, p.StorageQuotas)
+}
+
+// StorageQuota returns the quota for namespace, if it has one.
+func (p Params) StorageQuota(namespace string) (StorageQuota, bool) {
+	for _, quota := range p.StorageQuotas {
+		if quota.Namespace == namespace {
+			return quota, true
+		}
+	}
+	return StorageQuota{}, false
+}
+
+// Implements params.ParamSet
+func (p *Params) ParamSetPairs() params.ParamSetPairs {
+	return params.ParamSetPairs{
+		{Key: KeyBeginBlockFailurePolicy, Value: &p.BeginBlockFailurePolicy},
+		{Key: KeyMaxRetries, Value: &p.MaxRetries},
+		{Key: KeyRetryBackoff, Value: &p.RetryBackoff},
+		{Key: KeyStorageGas, Value: &p.StorageGas},
+		{Key: KeyBeginBlockGasLimit, Value: &p.BeginBlockGasLimit},
+		{Key: KeyStorageQuotas, Value: &p.StorageQuotas},
+	}
+}
+
+// Default parameters for this module
+func DefaultParams() Params {
+	return NewParams(DefaultBeginBlockFailurePolicy, DefaultMaxRetries, DefaultRetryBackoff,
+		DefaultStorageGas, DefaultBeginBlockGasLimit, []StorageQuota{})
+}
+
+// Validate checks that the parameters have sensible values.
+func (p Params) Validate() error {
+	switch p.BeginBlockFailurePolicy {
+	case PolicyHalt, PolicyRetry, PolicyDegrade:
+	default:
+		return fmt.Errorf("unknown begin block failure policy %q; must be %s, %s or %s",
+			p.BeginBlockFailurePolicy, PolicyHalt, PolicyRetry, PolicyDegrade)
+	}
+	if p.RetryBackoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative: %s", p.RetryBackoff)
+	}
+	seen := make(map[string]bool, len(p.StorageQuotas))
+	for _, quota := range p.StorageQuotas {
+		if quota.Namespace == "" || strings.Contains(quota.Namespace, ".") {
+			return fmt.Errorf("storage quota namespace must be one path segment: %q", quota.Namespace)
+		}
+		if seen[quota.Namespace] {
+			return fmt.Errorf("duplicate storage quota for %s", quota.Namespace)
+		}
+		seen[quota.Namespace] = true
+	}
+	return nil
+}
@@ -1,10 +1,16 @@
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
 
 // Query Result Payload for a storage query
 type QueryResStorage struct {
 	Value string `json:"value"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
 }
 
 // implement fmt.Stringer
@@ -12,9 +18,38 @@ func (r QueryResStorage) String() string {
 	return r.Value
 }
 
+// Query Result Payload for a mailbox query
+type QueryResMailbox struct {
+	Peer   string          `json:"peer" yaml:"peer"`
+	Outbox []OutboxMessage `json:"outbox" yaml:"outbox"`
+	Ack    uint64          `json:"ack" yaml:"ack"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+func NewQueryResMailbox(peer string, mailbox Mailbox) QueryResMailbox {
+	outbox := mailbox.Outbox
+	if outbox == nil {
+		outbox = []OutboxMessage{}
+	}
+	return QueryResMailbox{
+		Peer:   peer,
+		Outbox: outbox,
+		Ack:    mailbox.Ack,
+	}
+}
+
+// implement fmt.Stringer
+func (r QueryResMailbox) String() string {
+	return Mailbox{Outbox: r.Outbox, Ack: r.Ack}.String()
+}
+
 // Query Result Payload for a keys query
 type QueryResKeys struct {
 	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty" yaml:"next,omitempty"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
 }
 
 // implement fmt.Stringer
@@ -23,5 +58,216 @@ func (r QueryResKeys) String() string {
 	if err != nil {
 		return ""
 	}
+	if r.Next != "" {
+		return fmt.Sprintf("%s\nnext: %s", bytes, r.Next)
+	}
 	return string(bytes)
 }
+
+// A page of a keys, entries, values or peers query is DefaultPageLimit long
+// unless the query asks for another limit, and never more than MaxPageLimit.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// PageLimit returns the length of the page that a query asking for limit gets.
+func PageLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return DefaultPageLimit
+	case limit > MaxPageLimit:
+		return MaxPageLimit
+	}
+	return limit
+}
+
+// QueryKeysParams asks for one page of a keys query
+type QueryKeysParams struct {
+	Start string `json:"start"`
+	Limit int    `json:"limit"`
+}
+
+func NewQueryKeysParams(start string, limit int) QueryKeysParams {
+	return QueryKeysParams{
+		Start: start,
+		Limit: limit,
+	}
+}
+
+// Query Result Payload for a has query
+type QueryResHas struct {
+	Has bool `json:"has" yaml:"has"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// implement fmt.Stringer
+func (r QueryResHas) String() string {
+	return fmt.Sprintf("%t", r.Has)
+}
+
+// Query Result Payload for a size query
+type QueryResSize struct {
+	Size uint64 `json:"size" yaml:"size"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// implement fmt.Stringer
+func (r QueryResSize) String() string {
+	return fmt.Sprintf("%d", r.Size)
+}
+
+// Query Result Payload for an entries query
+type QueryResEntries struct {
+	Entries []KeyValue `json:"entries" yaml:"entries"`
+	Next    string     `json:"next,omitempty" yaml:"next,omitempty"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// implement fmt.Stringer
+func (r QueryResEntries) String() string {
+	lines := make([]string, 0, len(r.Entries)+1)
+	for _, e := range r.Entries {
+		value, _ := json.Marshal(e.Value)
+		lines = append(lines, fmt.Sprintf("%s: %s", e.Key, value))
+	}
+	if r.Next != "" {
+		lines = append(lines, fmt.Sprintf("next: %s", r.Next))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Query Result Payload for a values query
+type QueryResValues struct {
+	// Null where a key has no value
+	Values []*string `json:"values" yaml:"values"`
+	Next   string    `json:"next,omitempty" yaml:"next,omitempty"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// implement fmt.Stringer
+func (r QueryResValues) String() string {
+	bytes, err := json.Marshal(r.Values)
+	if err != nil {
+		return ""
+	}
+	if r.Next != "" {
+		return fmt.Sprintf("%s\nnext: %s", bytes, r.Next)
+	}
+	return string(bytes)
+}
+
+// Query Result Payload for a tree query
+type QueryResTree struct {
+	Tree StorageTree `json:"tree" yaml:"tree"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// implement fmt.Stringer
+func (r QueryResTree) String() string {
+	bytes, err := json.MarshalIndent(r.Tree, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(bytes)
+}
+
+// QueryTreeParams asks for a tree down to Depth levels of children
+type QueryTreeParams struct {
+	Depth int `json:"depth"`
+}
+
+func NewQueryTreeParams(depth int) QueryTreeParams {
+	return QueryTreeParams{
+		Depth: depth,
+	}
+}
+
+// Query Result Payload for a peers query
+type QueryResPeers struct {
+	Peers []PeerInfo `json:"peers" yaml:"peers"`
+	Next  string     `json:"next,omitempty" yaml:"next,omitempty"`
+	// The height the query was served at, if known
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// implement fmt.Stringer
+func (r QueryResPeers) String() string {
+	lines := make([]string, 0, len(r.Peers)+1)
+	for _, p := range r.Peers {
+		lines = append(lines, fmt.Sprintf("%s: %d outbound, ack %d", p.Peer, p.OutboxLength, p.Ack))
+	}
+	if r.Next != "" {
+		lines = append(lines, fmt.Sprintf("next: %s", r.Next))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// QueryPeersParams asks for one page of a peers query
+type QueryPeersParams struct {
+	Start string `json:"start"`
+	Limit int    `json:"limit"`
+}
+
+func NewQueryPeersParams(start string, limit int) QueryPeersParams {
+	return QueryPeersParams{
+		Start: start,
+		Limit: limit,
+	}
+}
+
+// Query Result Payload for a status query
+type QueryResStatus struct {
+	Params        Params `json:"params"`
+	Degraded      bool   `json:"degraded"`
+	DegradedSince int64  `json:"degraded_since"`
+}
+
+// implement fmt.Stringer
+func (r QueryResStatus) String() string {
+	status := "healthy"
+	if r.Degraded {
+		status = fmt.Sprintf("degraded since height %d", r.DegradedSince)
+	}
+	return fmt.Sprintf("Kernel: %s\n%s", status, r.Params)
+}
+
+// NamespaceUsage is how much storage a namespace uses, and its quota.  A
+// zero maximum means no limit.
+type NamespaceUsage struct {
+	Namespace  string `json:"namespace" yaml:"namespace"`
+	Bytes      uint64 `json:"bytes" yaml:"bytes"`
+	Entries    uint64 `json:"entries" yaml:"entries"`
+	MaxBytes   uint64 `json:"max_bytes" yaml:"max_bytes"`
+	MaxEntries uint64 `json:"max_entries" yaml:"max_entries"`
+}
+
+func NewNamespaceUsage(namespace string, usage StorageUsage, quota StorageQuota) NamespaceUsage {
+	return NamespaceUsage{
+		Namespace:  namespace,
+		Bytes:      usage.Bytes,
+		Entries:    usage.Entries,
+		MaxBytes:   quota.MaxBytes,
+		MaxEntries: quota.MaxEntries,
+	}
+}
+
+// Query Result Payload for a usage query
+type QueryResUsage struct {
+	Namespaces []NamespaceUsage `json:"namespaces"`
+}
+
+// implement fmt.Stringer
+func (r QueryResUsage) String() string {
+	lines := make([]string, len(r.Namespaces))
+	for i, u := range r.Namespaces {
+		lines[i] = fmt.Sprintf("%s: %d/%d bytes, %d/%d entries",
+			u.Namespace, u.Bytes, u.MaxBytes, u.Entries, u.MaxEntries)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -3,10 +3,10 @@ package types
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 )
 
-const EmptyMailboxValue = "\"{\\\"outbox\\\":[], \\\"ack\\\":0}\""
-
 type Storage struct {
 	Value string `json:"value"`
 }
@@ -15,13 +15,6 @@ func NewStorage() Storage {
 	return Storage{}
 }
 
-// Returns a new Mailbox with an empty mailbox
-func NewMailbox() Storage {
-	return Storage{
-		Value: EmptyMailboxValue,
-	}
-}
-
 type Keys struct {
 	Keys []string `json:"keys"`
 }
@@ -30,6 +23,61 @@ func NewKeys() Keys {
 	return Keys{}
 }
 
+// PeerInfo summarizes a peer's mailbox.
+type PeerInfo struct {
+	Peer         string `json:"peer" yaml:"peer"`
+	OutboxLength int    `json:"outbox_length" yaml:"outbox_length"`
+	Ack          uint64 `json:"ack" yaml:"ack"`
+}
+
+// NewPeerInfo summarizes the mailbox of peer.
+func NewPeerInfo(peer string, mailbox Mailbox) PeerInfo {
+	return PeerInfo{Peer: peer, OutboxLength: len(mailbox.Outbox), Ack: mailbox.Ack}
+}
+
+// StorageEntry is the value at one storage path.
+type StorageEntry struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// KeyValue is a child key and its value, or null if it has none.
+type KeyValue struct {
+	Key   string  `json:"key" yaml:"key"`
+	Value *string `json:"value" yaml:"value"`
+}
+
+// StorageTree is the value at a storage path, or null if it has none, and the
+// trees of its children.
+type StorageTree struct {
+	Path     string        `json:"path" yaml:"path"`
+	Value    *string       `json:"value" yaml:"value,omitempty"`
+	Children []StorageTree `json:"children,omitempty" yaml:"children,omitempty"`
+	// Whether it has children that were left out for being too deep, or
+	// for making the tree too big
+	Truncated bool `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+}
+
+// StorageChange is what an operation did to one storage path.
+type StorageChange struct {
+	Path    string
+	Value   string
+	Deleted bool
+}
+
+// StorageUsage is how much storage a namespace uses.  Each entry counts the
+// bytes of its path and value.
+type StorageUsage struct {
+	Bytes   uint64 `json:"bytes"`
+	Entries uint64 `json:"entries"`
+}
+
+// KernelStatus is how the kernel has been doing at BEGIN_BLOCK.
+type KernelStatus struct {
+	Degraded      bool  `json:"degraded"`
+	DegradedSince int64 `json:"degraded_since"`
+}
+
 // FIXME: Should have @agoric/nat
 func Nat(num float64) (int, error) {
 	nat := int(num)
@@ -95,3 +143,40 @@ func UnmarshalMessagesJSON(jsonString string) (*Messages, error) {
 
 	return ret, nil
 }
+
+// InvariantResult is what checking one invariant of the store found
+type InvariantResult struct {
+	Route   string `json:"route" yaml:"route"`
+	Broken  bool   `json:"broken" yaml:"broken"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// InvariantResults is what checking the store's invariants found
+type InvariantResults struct {
+	Results []InvariantResult `json:"results" yaml:"results"`
+	// The height the store was checked at
+	Height int64 `json:"height,omitempty" yaml:"height,omitempty"`
+}
+
+// Broken says whether any invariant is broken.
+func (r InvariantResults) Broken() bool {
+	for _, result := range r.Results {
+		if result.Broken {
+			return true
+		}
+	}
+	return false
+}
+
+// implement fmt.Stringer
+func (r InvariantResults) String() string {
+	lines := make([]string, len(r.Results))
+	for i, result := range r.Results {
+		if result.Broken {
+			lines[i] = fmt.Sprintf("%s: broken\n%s", result.Route, strings.TrimSuffix(result.Message, "\n"))
+		} else {
+			lines[i] = fmt.Sprintf("%s: ok", result.Route)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
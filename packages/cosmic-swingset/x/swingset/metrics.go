@@ -0,0 +1,105 @@
+package swingset
+
+import (
+	"sync/atomic"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// MetricsSubsystem is a subsystem shared by all metrics exposed by this
+	// package.
+	MetricsSubsystem = "swingset"
+)
+
+// Metrics contains metrics exposed by this package.
+type Metrics struct {
+	// Time the controller took to answer each call, by action type.
+	CallSeconds metrics.Histogram
+	// Number of storage requests from the controller, by method.
+	StorageOps metrics.Counter
+	// Bytes of storage requests and replies, by method.
+	StorageBytes metrics.Counter
+	// Number of inbound deliveries in the last block.
+	BlockDeliveries metrics.Gauge
+	// Histogram of the sizes of mailboxes written by the controller, in bytes.
+	MailboxSizeBytes metrics.Histogram
+	// Number of acknowledged messages trimmed from outboxes.
+	OutboxTrimmed metrics.Counter
+
+	deliveries int64
+}
+
+// PrometheusMetrics returns Metrics build using Prometheus client library.
+// Optionally, labels can be provided along with their values ("foo",
+// "fooValue").
+func PrometheusMetrics(namespace string, labelsAndValues ...string) *Metrics {
+	labels := []string{}
+	for i := 0; i < len(labelsAndValues); i += 2 {
+		labels = append(labels, labelsAndValues[i])
+	}
+	return &Metrics{
+		CallSeconds: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "call_seconds",
+			Help:      "Time the controller took to answer a call, by action type.",
+			Buckets:   stdprometheus.ExponentialBuckets(0.001, 4, 10),
+		}, append(labels, "action_type")).With(labelsAndValues...),
+		StorageOps: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "storage_ops",
+			Help:      "Number of storage requests from the controller, by method.",
+		}, append(labels, "method")).With(labelsAndValues...),
+		StorageBytes: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "storage_bytes",
+			Help:      "Bytes of storage requests and replies, by method.",
+		}, append(labels, "method")).With(labelsAndValues...),
+		BlockDeliveries: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "block_deliveries",
+			Help:      "Number of inbound deliveries in the last block.",
+		}, labels).With(labelsAndValues...),
+		MailboxSizeBytes: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "mailbox_size_bytes",
+			Help:      "Sizes of mailboxes written by the controller, in bytes.",
+			Buckets:   stdprometheus.ExponentialBuckets(64, 4, 10),
+		}, labels).With(labelsAndValues...),
+		OutboxTrimmed: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "outbox_trimmed_messages",
+			Help:      "Number of acknowledged messages trimmed from outboxes.",
+		}, labels).With(labelsAndValues...),
+	}
+}
+
+// NopMetrics returns no-op Metrics.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		CallSeconds:      discard.NewHistogram(),
+		StorageOps:       discard.NewCounter(),
+		StorageBytes:     discard.NewCounter(),
+		BlockDeliveries:  discard.NewGauge(),
+		MailboxSizeBytes: discard.NewHistogram(),
+		OutboxTrimmed:    discard.NewCounter(),
+	}
+}
+
+func (m *Metrics) countDelivery() {
+	atomic.AddInt64(&m.deliveries, 1)
+}
+
+// endBlock reports the deliveries counted since the last call.
+func (m *Metrics) endBlock() {
+	m.BlockDeliveries.Set(float64(atomic.SwapInt64(&m.deliveries, 0)))
+}
@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Kernel error codes
+const (
+	// The action or its messages were unacceptable.
+	ErrorCodeMalformed = "malformed"
+	// The kernel failed; this is a bug.
+	ErrorCodeInternal = "internal"
+	// The kernel ran out of some resource, such as memory or a meter.
+	ErrorCodeResourceExhausted = "resource_exhausted"
+)
+
+// KernelError is how the controller rejects an action.  It is sent as the
+// JSON text of the rejection.
+type KernelError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+func (ke *KernelError) Error() string {
+	if len(ke.Details) == 0 {
+		return fmt.Sprintf("%s: %s", ke.Code, ke.Message)
+	}
+	return fmt.Sprintf("%s: %s (%s)", ke.Code, ke.Message, ke.Details)
+}
+
+// ParseKernelError decodes the text of a rejection.  Controllers that don't
+// send structured errors have all their rejections treated as internal.
+func ParseKernelError(str string) *KernelError {
+	var ke KernelError
+	if err := json.Unmarshal([]byte(str), &ke); err != nil || ke.Code == "" {
+		return &KernelError{Code: ErrorCodeInternal, Message: str}
+	}
+	switch ke.Code {
+	case ErrorCodeMalformed, ErrorCodeInternal, ErrorCodeResourceExhausted:
+	default:
+		ke.Message = fmt.Sprintf("unknown error code %q: %s", ke.Code, ke.Message)
+		ke.Code = ErrorCodeInternal
+	}
+	return &ke
+}
@@ -0,0 +1,236 @@
+// Package protocol defines the messages exchanged between the swingset module
+// and its controller, the SwingSet kernel.  Both sides check the protocol
+// version when the controller is initialized, so that they cannot silently
+// drift apart when only one of them is upgraded.
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Version is the version of the protocol defined here.  Version 2 added
+// savepoints, which every controller must support.
+const Version = 2
+
+// Action types
+const (
+	TypeInit            = "AG_COSMOS_INIT"
+	TypeBeginBlock      = "BEGIN_BLOCK"
+	TypeDeliverInbound  = "DELIVER_INBOUND"
+	TypeSavepoint       = "SAVEPOINT"
+	TypeCommitSavepoint = "COMMIT_SAVEPOINT"
+	TypeAbortSavepoint  = "ABORT_SAVEPOINT"
+)
+
+// Optional protocol features
+const (
+	// Storage ports accept a "batch" of operations, applied all or nothing.
+	CapabilityBatch = "batch"
+)
+
+// Capabilities are the optional protocol features that Go understands.  Only
+// those the controller also announces are used.
+var Capabilities = []string{CapabilityBatch}
+
+// Action is the part common to every action.  The correlation ID only
+// serves to match up log lines on both sides; it must not affect state.
+type Action struct {
+	Type          string `json:"type"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// Init is sent whenever a controller is connected.
+type Init struct {
+	Type            string   `json:"type"`
+	CorrelationID   string   `json:"correlationId,omitempty"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// InitReply is the controller's answer to Init.
+type InitReply struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// BeginBlock is sent at the start of every block.
+type BeginBlock struct {
+	Type          string `json:"type"`
+	CorrelationID string `json:"correlationId,omitempty"`
+	StoragePort   int    `json:"storagePort"`
+	BlockHeight   int64  `json:"blockHeight"`
+	BlockTime     int64  `json:"blockTime"`
+}
+
+// DeliverInbound hands the kernel messages from a peer.
+type DeliverInbound struct {
+	Type          string    `json:"type"`
+	CorrelationID string    `json:"correlationId,omitempty"`
+	Peer          string    `json:"peer"`
+	Messages      []Message `json:"messages"`
+	Ack           int       `json:"ack"`
+	StoragePort   int       `json:"storagePort"`
+	BlockHeight   int64     `json:"blockHeight"`
+	BlockTime     int64     `json:"blockTime"`
+}
+
+// Savepoint sets, commits or aborts a savepoint in the kernel.  Aborting one
+// returns the kernel to its state when the savepoint was set.  Savepoints
+// nest, and are always committed or aborted innermost first; the kernel only
+// keeps its state once no savepoint is left.
+type Savepoint struct {
+	Type          string `json:"type"`
+	CorrelationID string `json:"correlationId,omitempty"`
+	Savepoint     int    `json:"savepoint"`
+}
+
+// Message is a numbered message, encoded as a [num, body] pair.
+type Message struct {
+	Num  int
+	Body string
+}
+
+// StorageRequest is what the kernel sends to a storage port.  A "batch"
+// carries its operations in Ops, and is answered with a JSON array of their
+// answers.  A "keys", "entries" or "values" with a Start or Limit is
+// answered with one StoragePage.  A "copySubtree" or "move" copies the
+// subtree at Key to Dest.
+type StorageRequest struct {
+	Method string           `json:"method"`
+	Key    string           `json:"key"`
+	Value  string           `json:"value"`
+	Dest   string           `json:"dest,omitempty"`
+	Ops    []StorageRequest `json:"ops,omitempty"`
+	Start  string           `json:"start,omitempty"`
+	Limit  int              `json:"limit,omitempty"`
+}
+
+// StoragePage is one page of children.  If Next is not empty, the following
+// page is read by asking again with it as Start.
+type StoragePage struct {
+	Items interface{} `json:"items"`
+	Next  string      `json:"next,omitempty"`
+}
+
+// DoneReply is how the controller answers a BeginBlock, DeliverInbound or
+// Savepoint that it has finished.
+const DoneReply = "true"
+
+// Session records what was negotiated with a controller.
+type Session struct {
+	ProtocolVersion int
+	Capabilities    map[string]bool
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{m.Num, m.Body})
+}
+
+func (m *Message) UnmarshalJSON(bz []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(bz, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return errors.New("message is not a [num, body] pair")
+	}
+	if err := json.Unmarshal(pair[0], &m.Num); err != nil {
+		return fmt.Errorf("message num: %s", err)
+	}
+	if err := json.Unmarshal(pair[1], &m.Body); err != nil {
+		return fmt.Errorf("message body: %s", err)
+	}
+	return nil
+}
+
+// NewInit returns the Init action for this version of the protocol.
+func NewInit() Init {
+	return Init{
+		Type:            TypeInit,
+		CorrelationID:   NewCorrelationID(),
+		ProtocolVersion: Version,
+		Capabilities:    Capabilities,
+	}
+}
+
+// NewCorrelationID returns a fresh random ID for an action.
+func NewCorrelationID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// ParseAction returns the type of a serialized action.
+func ParseAction(str string) (string, error) {
+	action, err := ParseActionHeader(str)
+	return action.Type, err
+}
+
+// ParseActionHeader returns the part common to every action.
+func ParseActionHeader(str string) (Action, error) {
+	var action Action
+	if err := json.Unmarshal([]byte(str), &action); err != nil {
+		return action, fmt.Errorf("malformed action: %s", err)
+	}
+	if action.Type == "" {
+		return action, errors.New("malformed action: missing type")
+	}
+	return action, nil
+}
+
+// ParseInitReply checks the controller's answer to Init, and returns what
+// the two sides agreed on.
+func ParseInitReply(reply string) (Session, error) {
+	var session Session
+	var initReply InitReply
+	if err := json.Unmarshal([]byte(reply), &initReply); err != nil {
+		return session, fmt.Errorf("malformed %s reply %q: %s", TypeInit, reply, err)
+	}
+	if initReply.ProtocolVersion != Version {
+		return session, fmt.Errorf(
+			"controller speaks protocol version %d, but we speak version %d",
+			initReply.ProtocolVersion, Version,
+		)
+	}
+
+	session.ProtocolVersion = initReply.ProtocolVersion
+	session.Capabilities = map[string]bool{}
+	for _, theirs := range initReply.Capabilities {
+		for _, ours := range Capabilities {
+			if theirs == ours {
+				session.Capabilities[ours] = true
+			}
+		}
+	}
+	return session, nil
+}
+
+// CheckDoneReply checks the controller's answer to a BeginBlock,
+// DeliverInbound or Savepoint.
+func CheckDoneReply(actionType, reply string) error {
+	if reply != DoneReply {
+		return fmt.Errorf("malformed %s reply %q: expected %s", actionType, reply, DoneReply)
+	}
+	return nil
+}
+
+// Has tells whether a capability was negotiated.
+func (s Session) Has(capability string) bool {
+	return s.Capabilities[capability]
+}
+
+func (s Session) String() string {
+	caps := make([]string, 0, len(s.Capabilities))
+	for capability := range s.Capabilities {
+		caps = append(caps, capability)
+	}
+	sort.Strings(caps)
+	return fmt.Sprintf("version %d, capabilities %v", s.ProtocolVersion, caps)
+}
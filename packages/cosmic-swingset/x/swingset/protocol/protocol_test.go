@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseInitReply(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		reply string
+		want  map[string]bool
+		err   bool
+	}{
+		{"all capabilities",
+			fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["batch"]}`, Version),
+			map[string]bool{CapabilityBatch: true}, false},
+		{"no capabilities",
+			fmt.Sprintf(`{"protocolVersion":%d}`, Version),
+			map[string]bool{}, false},
+		{"capabilities we don't know are ignored",
+			fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["teleport","batch"]}`, Version),
+			map[string]bool{CapabilityBatch: true}, false},
+		{"older version",
+			fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["batch"]}`, Version-1),
+			nil, true},
+		{"newer version",
+			fmt.Sprintf(`{"protocolVersion":%d,"capabilities":["batch"]}`, Version+1),
+			nil, true},
+		{"no version", `{"capabilities":["batch"]}`, nil, true},
+		// What a controller from before the protocol answers
+		{"bare true", `true`, nil, true},
+		{"not JSON", `ok`, nil, true},
+	} {
+		session, err := ParseInitReply(tc.reply)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: ParseInitReply(%s) = %s, want an error", tc.name, tc.reply, session)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseInitReply(%s): %s", tc.name, tc.reply, err)
+			continue
+		}
+		if session.ProtocolVersion != Version || !reflect.DeepEqual(session.Capabilities, tc.want) {
+			t.Errorf("%s: ParseInitReply(%s) = %s, want capabilities %v", tc.name, tc.reply, session, tc.want)
+		}
+		for capability, want := range tc.want {
+			if session.Has(capability) != want {
+				t.Errorf("%s: Has(%s) = %t", tc.name, capability, !want)
+			}
+		}
+	}
+}
+
+func TestParseKernelError(t *testing.T) {
+	for _, tc := range []struct {
+		str  string
+		want KernelError
+	}{
+		{`{"code":"malformed","message":"bad ack"}`,
+			KernelError{Code: ErrorCodeMalformed, Message: "bad ack"}},
+		{`{"code":"resource_exhausted","message":"meter","details":{"limit":5}}`,
+			KernelError{Code: ErrorCodeResourceExhausted, Message: "meter", Details: []byte(`{"limit":5}`)}},
+		{`{"code":"internal","message":"bug"}`,
+			KernelError{Code: ErrorCodeInternal, Message: "bug"}},
+		// A code we don't know is internal, keeping what it was.
+		{`{"code":"cosmic_rays","message":"flip"}`,
+			KernelError{Code: ErrorCodeInternal, Message: `unknown error code "cosmic_rays": flip`}},
+		// Unstructured rejections are internal, with their text as the message.
+		{`Error: out of memory`,
+			KernelError{Code: ErrorCodeInternal, Message: `Error: out of memory`}},
+		{`{"message":"no code"}`,
+			KernelError{Code: ErrorCodeInternal, Message: `{"message":"no code"}`}},
+		{`"a string"`,
+			KernelError{Code: ErrorCodeInternal, Message: `"a string"`}},
+	} {
+		got := ParseKernelError(tc.str)
+		if got.Code != tc.want.Code || got.Message != tc.want.Message || string(got.Details) != string(tc.want.Details) {
+			t.Errorf("ParseKernelError(%s) = %+v, want %+v", tc.str, *got, tc.want)
+		}
+	}
+}
+
+func TestKernelErrorString(t *testing.T) {
+	for _, tc := range []struct {
+		ke   KernelError
+		want string
+	}{
+		{KernelError{Code: ErrorCodeMalformed, Message: "bad ack"}, "malformed: bad ack"},
+		{KernelError{Code: ErrorCodeInternal, Message: "bug", Details: []byte(`[1]`)}, "internal: bug ([1])"},
+	} {
+		if got := tc.ke.Error(); got != tc.want {
+			t.Errorf("Error() = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestParseActionHeader(t *testing.T) {
+	for _, tc := range []struct {
+		str  string
+		want Action
+		err  bool
+	}{
+		{`{"type":"BEGIN_BLOCK","correlationId":"abc","blockHeight":3}`,
+			Action{Type: TypeBeginBlock, CorrelationID: "abc"}, false},
+		{`{"type":"SAVEPOINT","savepoint":1}`, Action{Type: TypeSavepoint}, false},
+		{`{"correlationId":"abc"}`, Action{}, true},
+		{`[]`, Action{}, true},
+		{`not json`, Action{}, true},
+	} {
+		got, err := ParseActionHeader(tc.str)
+		if (err != nil) != tc.err {
+			t.Errorf("ParseActionHeader(%s) error = %v, want error %t", tc.str, err, tc.err)
+			continue
+		}
+		if !tc.err && got != tc.want {
+			t.Errorf("ParseActionHeader(%s) = %+v, want %+v", tc.str, got, tc.want)
+		}
+	}
+}
+
+func TestCheckDoneReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		err   bool
+	}{
+		{DoneReply, false},
+		{`false`, true},
+		{`"true"`, true},
+		{``, true},
+	} {
+		if err := CheckDoneReply(TypeBeginBlock, tc.reply); (err != nil) != tc.err {
+			t.Errorf("CheckDoneReply(%q) = %v, want error %t", tc.reply, err, tc.err)
+		}
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	for _, tc := range []struct {
+		str  string
+		want Message
+		err  bool
+	}{
+		{`[3,"hello"]`, Message{Num: 3, Body: "hello"}, false},
+		{`[3]`, Message{}, true},
+		{`[3,"a","b"]`, Message{}, true},
+		{`["3","hello"]`, Message{}, true},
+		{`{"num":3,"body":"hello"}`, Message{}, true},
+	} {
+		var got Message
+		err := got.UnmarshalJSON([]byte(tc.str))
+		if (err != nil) != tc.err {
+			t.Errorf("unmarshal %s: error = %v, want error %t", tc.str, err, tc.err)
+			continue
+		}
+		if tc.err {
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("unmarshal %s = %+v, want %+v", tc.str, got, tc.want)
+		}
+		if bz, err := got.MarshalJSON(); err != nil || string(bz) != tc.str {
+			t.Errorf("marshal %+v = %s, %v, want %s", got, bz, err, tc.str)
+		}
+	}
+}
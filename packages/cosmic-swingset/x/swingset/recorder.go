@@ -0,0 +1,100 @@
+package swingset
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Kinds of Record
+const (
+	RecordCall         = "call"
+	RecordSend         = "send"
+	RecordReply        = "reply"
+	RecordReceive      = "receive"
+	RecordReceiveReply = "receiveReply"
+	RecordGas          = "gas"
+)
+
+// Record is one message that crossed the bridge.  Calls and sends go to the
+// controller, and replies come back; receives come from the controller to a
+// port, and receive replies answer them.  A gas record notes the gas meter
+// that a storage port's requests are charged to, so that replay can charge
+// them alike.
+type Record struct {
+	Kind        string `json:"kind"`
+	Port        int    `json:"port,omitempty"`
+	ReplyPort   int    `json:"replyPort,omitempty"`
+	Body        string `json:"body"`
+	Error       string `json:"error,omitempty"`
+	GasLimit    uint64 `json:"gasLimit,omitempty"`
+	GasConsumed uint64 `json:"gasConsumed,omitempty"`
+}
+
+// Recorder appends every Record to a file, one JSON object per line.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	err  error
+}
+
+// OpenRecorder opens path for appending records.
+func OpenRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record writes rec.  The first write error is kept, and returned by Close.
+func (r *Recorder) Record(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(rec)
+	}
+}
+
+// Close puts what was recorded on disk and closes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.file.Sync(); r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// ReadRecording reads back every Record in a file written by a Recorder.
+func ReadRecording(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// ErrorString is how a Record keeps err: its message, or "" if there is none.
+func ErrorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
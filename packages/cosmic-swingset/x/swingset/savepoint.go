@@ -0,0 +1,109 @@
+package swingset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// Savepoint is a point in the kernel's state that it can be returned to, so
+// that the kernel keeps what it did for a tx exactly when Cosmos keeps the
+// tx's writes.  Savepoints nest, and are committed or aborted innermost
+// first.
+type Savepoint struct {
+	bridge *Bridge
+	id     int
+}
+
+// Savepoint sets a savepoint in the kernel.  A nil Bridge, as used offline,
+// has no kernel to roll back, and sets a nil Savepoint that does nothing.
+func (b *Bridge) Savepoint(ctx context.Context) (*Savepoint, error) {
+	if b == nil {
+		return nil, nil
+	}
+	b.mu.Lock()
+	b.lastSavepoint++
+	sp := &Savepoint{bridge: b, id: b.lastSavepoint}
+	b.mu.Unlock()
+
+	if err := sp.send(ctx, protocol.TypeSavepoint); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.savepoints = append(b.savepoints, sp)
+	b.mu.Unlock()
+	return sp, nil
+}
+
+// Commit keeps what the kernel did since the savepoint was set, as part of
+// the enclosing savepoint if there is one.
+func (sp *Savepoint) Commit(ctx context.Context) error {
+	return sp.end(ctx, protocol.TypeCommitSavepoint)
+}
+
+// Abort returns the kernel to its state when the savepoint was set.
+func (sp *Savepoint) Abort(ctx context.Context) error {
+	return sp.end(ctx, protocol.TypeAbortSavepoint)
+}
+
+func (sp *Savepoint) end(ctx context.Context, typ string) error {
+	if sp == nil {
+		return nil
+	}
+	b := sp.bridge
+	b.mu.Lock()
+	n := len(b.savepoints)
+	if n == 0 || b.savepoints[n-1] != sp {
+		b.mu.Unlock()
+		return fmt.Errorf("savepoint %d is not the innermost one open", sp.id)
+	}
+	b.savepoints = b.savepoints[:n-1]
+	b.mu.Unlock()
+	return sp.send(ctx, typ)
+}
+
+func (sp *Savepoint) send(ctx context.Context, typ string) error {
+	action := &protocol.Savepoint{
+		Type:          typ,
+		CorrelationID: protocol.NewCorrelationID(),
+		Savepoint:     sp.id,
+	}
+	bz, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	out, err := sp.bridge.CallToNode(ctx, string(bz))
+	if err != nil {
+		return err
+	}
+	return protocol.CheckDoneReply(typ, out)
+}
+
+// EndSavepoints commits every savepoint still open if keep is true, and
+// aborts them otherwise.  Deliveries leave their savepoints open for the tx
+// to end once it knows whether it succeeded.
+func (b *Bridge) EndSavepoints(ctx context.Context, keep bool) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		var sp *Savepoint
+		if n := len(b.savepoints); n > 0 {
+			sp = b.savepoints[n-1]
+		}
+		b.mu.Unlock()
+		if sp == nil {
+			return nil
+		}
+		end := sp.Abort
+		if keep {
+			end = sp.Commit
+		}
+		if err := end(ctx); err != nil {
+			return err
+		}
+	}
+}
@@ -1,38 +1,64 @@
 package swingset
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
 )
 
+// Longer values are only described by their hash in storage events.
+const maxStorageEventValueLength = 1024
+
 type storageHandler struct {
 	Keeper  Keeper
 	Context sdk.Context
-}
-
-type storageMessage struct {
-	Method string `json:"method"`
-	Key    string `json:"key"`
-	Value  string `json:"value"`
+	Metrics *Metrics
+	// The kernel's storage operations are charged to GasMeter according to
+	// Gas, rather than by what the KVStore does for them.
+	GasMeter sdk.GasMeter
+	Gas      GasSchedule
+	// Set once the kernel has run out of gas, after which it gets no more
+	// storage.
+	OutOfGas error
+	// Set if the keeper refused a write, such as one over quota.
+	Refused sdk.Error
 }
 
 func NewStorageHandler(context sdk.Context, keeper Keeper) *storageHandler {
+	// The kernel's storage is only charged for through GasMeter.
+	ctx := context.WithGasMeter(sdk.NewInfiniteGasMeter())
 	return &storageHandler{
-		Keeper:  keeper,
-		Context: context,
+		Keeper:   keeper,
+		Context:  ctx,
+		Metrics:  NopMetrics(),
+		GasMeter: context.GasMeter(),
+		Gas:      keeper.GetParams(ctx).StorageGas,
 	}
 }
 
 func (sh *storageHandler) Receive(str string) (ret string, err error) {
-	msg := new(storageMessage)
+	if sh.OutOfGas != nil {
+		return "", sh.OutOfGas
+	}
+
+	msg := new(protocol.StorageRequest)
 	err = json.Unmarshal([]byte(str), &msg)
 	if err != nil {
 		return
 	}
 
+	defer func() {
+		sh.observe(msg, len(str)+len(ret))
+	}()
+
 	// Allow recovery from OutOfGas panics so that we don't crash
 	defer func() {
 		if r := recover(); r != nil {
@@ -40,8 +66,9 @@ func (sh *storageHandler) Receive(str string) (ret string, err error) {
 			case sdk.ErrorOutOfGas:
 				err = fmt.Errorf(
 					"out of gas in location: %v; gasUsed: %d",
-					rType.Descriptor, sh.Context.GasMeter().GasConsumed(),
+					rType.Descriptor, sh.GasMeter.GasConsumed(),
 				)
+				sh.OutOfGas = err
 			default:
 				// Not ErrorOutOfGas, so panic again.
 				panic(r)
@@ -49,20 +76,163 @@ func (sh *storageHandler) Receive(str string) (ret string, err error) {
 		}
 	}()
 
+	if msg.Method == "batch" {
+		return sh.batch(msg.Ops)
+	}
+	return sh.receive(msg)
+}
+
+func (sh *storageHandler) observe(msg *protocol.StorageRequest, bytes int) {
+	sh.Metrics.StorageOps.With("method", msg.Method).Add(1)
+	sh.Metrics.StorageBytes.With("method", msg.Method).Add(float64(bytes))
+	if msg.Method == "set" && strings.HasPrefix(msg.Key, "mailbox") {
+		sh.Metrics.MailboxSizeBytes.Observe(float64(len(msg.Value)))
+	}
+}
+
+// batch applies ops in order, and only keeps their effects if all succeed.
+func (sh *storageHandler) batch(ops []protocol.StorageRequest) (string, error) {
+	cacheCtx, writeCache := sh.Context.CacheContext()
+	cached := *sh
+	cached.Context = cacheCtx
+
+	results := make([]json.RawMessage, len(ops))
+	for i, op := range ops {
+		if op.Method == "batch" {
+			return "", fmt.Errorf("batch op %d: batches cannot be nested", i)
+		}
+		ret, err := cached.receive(&op)
+		sh.observe(&op, len(op.Key)+len(op.Value)+len(ret))
+		if err != nil {
+			sh.Refused = cached.Refused
+			return "", fmt.Errorf("batch op %d: %s", i, err)
+		}
+		results[i] = json.RawMessage(ret)
+	}
+
+	bytes, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+	writeCache()
+	sh.Context.EventManager().EmitEvents(cacheCtx.EventManager().Events())
+	return string(bytes), nil
+}
+
+// emitStorageEvent tells subscribers that path now has value.
+func (sh *storageHandler) emitStorageEvent(path, value string) {
+	valueAttribute := sdk.NewAttribute(AttributeKeyValue, value)
+	if len(value) > maxStorageEventValueLength {
+		hash := sha256.Sum256([]byte(value))
+		valueAttribute = sdk.NewAttribute(AttributeKeyValueHash, hex.EncodeToString(hash[:]))
+	}
+	sh.Context.EventManager().EmitEvent(
+		sdk.NewEvent(
+			EventTypeStorage,
+			sdk.NewAttribute(AttributeKeyPath, path),
+			valueAttribute,
+		),
+	)
+}
+
+// emitDeletedEvent tells subscribers that path no longer has a value.
+func (sh *storageHandler) emitDeletedEvent(path string) {
+	sh.Context.EventManager().EmitEvent(
+		sdk.NewEvent(
+			EventTypeStorage,
+			sdk.NewAttribute(AttributeKeyPath, path),
+			sdk.NewAttribute(AttributeKeyDeleted, "true"),
+		),
+	)
+}
+
+// gasCosts returns the flat and per-byte gas costs of method.
+func (sh *storageHandler) gasCosts(method string) (flat, perByte uint64) {
+	switch method {
+	case "set", "delete", "deletePrefix", "copySubtree", "move":
+		return sh.Gas.WriteCostFlat, sh.Gas.WriteCostPerByte
+	}
+	return sh.Gas.ReadCostFlat, sh.Gas.ReadCostPerByte
+}
+
+// applied charges for and announces each path that a subtree operation
+// changed, beyond the one the request paid for.
+func (sh *storageHandler) applied(descriptor string, changes []StorageChange, err sdk.Error) (string, error) {
+	if err != nil {
+		sh.Refused = err
+		return "", err
+	}
+	for _, change := range changes {
+		sh.GasMeter.ConsumeGas(sh.Gas.WriteCostFlat+sh.Gas.WriteCostPerByte*uint64(len(change.Path)+len(change.Value)), descriptor)
+		if change.Deleted {
+			sh.emitDeletedEvent(change.Path)
+		} else {
+			sh.emitStorageEvent(change.Path, change.Value)
+		}
+	}
+	return "true", nil
+}
+
+// TrimOutbox drops what peer has acknowledged from its outbox, charging for
+// the rewritten mailbox as a write.
+func (sh *storageHandler) TrimOutbox(peer string, ack uint64) (int, sdk.Error) {
+	trimmed, err := sh.Keeper.TrimOutbox(sh.Context, peer, ack)
+	if err != nil || trimmed == 0 {
+		return trimmed, err
+	}
+	path := "mailbox." + peer
+	value := sh.Keeper.GetStorage(sh.Context, path).Value
+	sh.GasMeter.ConsumeGas(sh.Gas.WriteCostFlat+sh.Gas.WriteCostPerByte*uint64(len(path)+len(value)), "swingset trim outbox")
+	sh.emitStorageEvent(path, value)
+	return trimmed, nil
+}
+
+func (sh *storageHandler) receive(msg *protocol.StorageRequest) (ret string, err error) {
+	// Pay for the request up front, and for the result once we have it.
+	descriptor := "swingset " + msg.Method
+	flat, perByte := sh.gasCosts(msg.Method)
+	sh.GasMeter.ConsumeGas(flat+perByte*uint64(len(msg.Key)+len(msg.Value)+len(msg.Dest)), descriptor)
+	defer func() {
+		sh.GasMeter.ConsumeGas(perByte*uint64(len(ret)), descriptor)
+	}()
+
 	// Handle generic paths.
 	switch msg.Method {
 	case "set":
 		storage := NewStorage()
 		storage.Value = msg.Value
 		//fmt.Printf("giving Keeper.SetStorage(%s) %s\n", msg.Key, storage.Value)
-		sh.Keeper.SetStorage(sh.Context, msg.Key, storage)
+		if err := sh.Keeper.SetStorage(sh.Context, msg.Key, storage); err != nil {
+			sh.Refused = err
+			return "", err
+		}
+		sh.emitStorageEvent(msg.Key, msg.Value)
 		return "true", nil
 
+	case "delete":
+		if sh.Keeper.HasStorage(sh.Context, msg.Key) {
+			sh.Keeper.DeleteStorage(sh.Context, msg.Key)
+			sh.emitDeletedEvent(msg.Key)
+		}
+		return "true", nil
+
+	case "deletePrefix":
+		changes, err := sh.Keeper.DeleteSubtree(sh.Context, msg.Key)
+		return sh.applied(descriptor, changes, err)
+
+	case "copySubtree":
+		changes, err := sh.Keeper.CopySubtree(sh.Context, msg.Key, msg.Dest)
+		return sh.applied(descriptor, changes, err)
+
+	case "move":
+		changes, err := sh.Keeper.MoveSubtree(sh.Context, msg.Key, msg.Dest)
+		return sh.applied(descriptor, changes, err)
+
 	case "get":
-		storage := sh.Keeper.GetStorage(sh.Context, msg.Key)
-		if storage.Value == "" {
+		if !sh.Keeper.HasStorage(sh.Context, msg.Key) {
 			return "null", nil
 		}
+		storage := sh.Keeper.GetStorage(sh.Context, msg.Key)
 		//fmt.Printf("Keeper.GetStorage gave us %s\n", storage.Value)
 		s, err := json.Marshal(storage.Value)
 		if err != nil {
@@ -71,58 +241,61 @@ func (sh *storageHandler) Receive(str string) (ret string, err error) {
 		return string(s), nil
 
 	case "has":
-		storage := sh.Keeper.GetStorage(sh.Context, msg.Key)
-		if storage.Value == "" {
+		if !sh.Keeper.HasStorage(sh.Context, msg.Key) {
 			return "false", nil
 		}
 		return "true", nil
 
 	case "keys":
-		keys := sh.Keeper.GetKeys(sh.Context, msg.Key)
-		if keys.Keys == nil {
-			return "[]", nil
-		}
-		bytes, err := json.Marshal(keys.Keys)
-		if err != nil {
-			return "", err
+		keys, next := sh.Keeper.GetKeysPage(sh.Context, msg.Key, msg.Start, msg.Limit)
+		if keys == nil {
+			keys = []string{}
 		}
-		return string(bytes), nil
+		return marshalPage(msg, keys, next)
 
 	case "entries":
-		keys := sh.Keeper.GetKeys(sh.Context, msg.Key)
-		ents := make([][]string, len(keys.Keys))
-		for i, key := range keys.Keys {
+		keys, next := sh.Keeper.GetKeysPage(sh.Context, msg.Key, msg.Start, msg.Limit)
+		ents := make([][]string, len(keys))
+		for i, key := range keys {
 			ents[i] = make([]string, 2)
 			ents[i][0] = key
 			storage := sh.Keeper.GetStorage(sh.Context, fmt.Sprintf("%s.%s", msg.Key, key))
 			ents[i][1] = storage.Value
 		}
-		bytes, err := json.Marshal(ents)
-		if err != nil {
-			return "", err
-		}
-		return string(bytes), nil
+		return marshalPage(msg, ents, next)
 
 	case "values":
-		keys := sh.Keeper.GetKeys(sh.Context, msg.Key)
-		vals := make([]string, len(keys.Keys))
-		for i, key := range keys.Keys {
+		keys, next := sh.Keeper.GetKeysPage(sh.Context, msg.Key, msg.Start, msg.Limit)
+		vals := make([]string, len(keys))
+		for i, key := range keys {
 			storage := sh.Keeper.GetStorage(sh.Context, fmt.Sprintf("%s.%s", msg.Key, key))
 			vals[i] = storage.Value
 		}
-		bytes, err := json.Marshal(vals)
-		if err != nil {
-			return "", err
-		}
-		return string(bytes), nil
+		return marshalPage(msg, vals, next)
 
 	case "size":
 		keys := sh.Keeper.GetKeys(sh.Context, msg.Key)
 		if keys.Keys == nil {
 			return "0", nil
 		}
-		return string(len(keys.Keys)), nil
+		return strconv.Itoa(len(keys.Keys)), nil
 	}
 
 	return "", errors.New("Unrecognized msg.Method " + msg.Method)
 }
+
+// marshalPage answers with just the items, unless the request asked for a
+// page.
+func marshalPage(msg *protocol.StorageRequest, items interface{}, next string) (string, error) {
+	var bytes []byte
+	var err error
+	if msg.Start == "" && msg.Limit <= 0 {
+		bytes, err = json.Marshal(items)
+	} else {
+		bytes, err = json.Marshal(protocol.StoragePage{Items: items, Next: next})
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
@@ -0,0 +1,162 @@
+package swingset
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/params"
+	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Agoric/cosmic-swingset/x/swingset/protocol"
+)
+
+// newTestKeeper returns a Keeper on an in-memory store, as InitGenesis would
+// leave it, and a context for it.
+func newTestKeeper(t *testing.T) (sdk.Context, Keeper) {
+	t.Helper()
+	key := sdk.NewKVStoreKey(StoreKey)
+	paramsKey := sdk.NewKVStoreKey(params.StoreKey)
+	paramsTKey := sdk.NewTransientStoreKey(params.TStoreKey)
+
+	db := dbm.NewMemDB()
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(key, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(paramsKey, sdk.StoreTypeIAVL, db)
+	ms.MountStoreWithDB(paramsTKey, sdk.StoreTypeTransient, db)
+	if err := ms.LoadLatestVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	cdc := codec.New()
+	RegisterCodec(cdc)
+	paramsKeeper := params.NewKeeper(cdc, paramsKey, paramsTKey, params.DefaultCodespace)
+	k := NewKeeper(nil, key, paramsKeeper.Subspace(DefaultParamspace), cdc, DefaultCodespace)
+	ctx := sdk.NewContext(ms, abci.Header{Height: 1}, false, log.NewNopLogger())
+	k.SetParams(ctx, DefaultParams())
+	k.SetStoreVersion(ctx, StoreVersion)
+	return ctx, k
+}
+
+// receive sends req to sh as the kernel would.
+func receive(t *testing.T, sh *storageHandler, req protocol.StorageRequest) (string, error) {
+	t.Helper()
+	bz, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sh.Receive(string(bz))
+}
+
+func TestStorageGas(t *testing.T) {
+	ctx, k := newTestKeeper(t)
+	g := DefaultParams().StorageGas
+	write := func(bytes int) uint64 { return g.WriteCostFlat + g.WriteCostPerByte*uint64(bytes) }
+	read := func(bytes int) uint64 { return g.ReadCostFlat + g.ReadCostPerByte*uint64(bytes) }
+
+	for _, tc := range []struct {
+		req  protocol.StorageRequest
+		want uint64
+	}{
+		{protocol.StorageRequest{Method: "set", Key: "data.a", Value: "hello"},
+			write(len("data.a") + len("hello") + len("true"))},
+		{protocol.StorageRequest{Method: "get", Key: "data.a"},
+			read(len("data.a") + len(`"hello"`))},
+		{protocol.StorageRequest{Method: "get", Key: "data.missing"},
+			read(len("data.missing") + len("null"))},
+		{protocol.StorageRequest{Method: "has", Key: "data.a"},
+			read(len("data.a") + len("true"))},
+		// Each entry a subtree operation changes is charged as a write.
+		{protocol.StorageRequest{Method: "copySubtree", Key: "data", Dest: "copy"},
+			write(len("data")+len("copy")+len("true")) + write(len("copy.a")+len("hello"))},
+	} {
+		ctx := ctx.WithGasMeter(sdk.NewInfiniteGasMeter())
+		sh := NewStorageHandler(ctx, k)
+		if _, err := receive(t, sh, tc.req); err != nil {
+			t.Fatalf("%s %s: %s", tc.req.Method, tc.req.Key, err)
+		}
+		if got := sh.GasMeter.GasConsumed(); got != tc.want {
+			t.Errorf("%s %s used %d gas, want %d", tc.req.Method, tc.req.Key, got, tc.want)
+		}
+	}
+}
+
+func TestStorageOutOfGas(t *testing.T) {
+	ctx, k := newTestKeeper(t)
+	ctx = ctx.WithGasMeter(sdk.NewGasMeter(DefaultParams().StorageGas.WriteCostFlat))
+	sh := NewStorageHandler(ctx, k)
+
+	if _, err := receive(t, sh, protocol.StorageRequest{Method: "set", Key: "data.a", Value: "hello"}); err == nil {
+		t.Fatal("set without the gas for it succeeded")
+	}
+	if sh.OutOfGas == nil {
+		t.Error("running out of gas wasn't noted")
+	}
+	if k.HasStorage(sh.Context, "data.a") {
+		t.Error("set without the gas for it was stored")
+	}
+
+	// Once out of gas, the kernel gets no more storage, even for free.
+	sh.GasMeter = sdk.NewInfiniteGasMeter()
+	if _, err := receive(t, sh, protocol.StorageRequest{Method: "has", Key: "data.a"}); err == nil {
+		t.Error("storage still answered after running out of gas")
+	}
+}
+
+func TestStorageBatchIsAllOrNothing(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		last    protocol.StorageRequest
+		refused bool
+		err     string
+	}{
+		{"quota exceeded", protocol.StorageRequest{Method: "set", Key: "data.big", Value: strings.Repeat("x", 100)}, true, "quota"},
+		{"malformed mailbox", protocol.StorageRequest{Method: "set", Key: "mailbox.peer", Value: "not json"}, true, "mailbox"},
+		{"nested batch", protocol.StorageRequest{Method: "batch"}, false, "nested"},
+		{"unknown method", protocol.StorageRequest{Method: "frobnicate", Key: "data.a"}, false, "frobnicate"},
+	} {
+		ctx, k := newTestKeeper(t)
+		if err := k.SetStorage(ctx, "data.old", Storage{Value: "old"}); err != nil {
+			t.Fatal(err)
+		}
+		// Room for the earlier ops, but not for the big one.
+		params := k.GetParams(ctx)
+		params.StorageQuotas = []StorageQuota{{Namespace: "data", MaxBytes: k.GetStorageUsage(ctx, "data").Bytes + 50}}
+		k.SetParams(ctx, params)
+		ctx = ctx.WithEventManager(sdk.NewEventManager())
+
+		sh := NewStorageHandler(ctx, k)
+		_, err := receive(t, sh, protocol.StorageRequest{Method: "batch", Ops: []protocol.StorageRequest{
+			{Method: "set", Key: "data.a", Value: "1"},
+			{Method: "set", Key: "data.old", Value: "new"},
+			{Method: "delete", Key: "data.old"},
+			{Method: "set", Key: "data.b", Value: "2"},
+			tc.last,
+		}})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: batch = %v, want an error about %s", tc.name, err, tc.err)
+			continue
+		}
+		if (sh.Refused != nil) != tc.refused {
+			t.Errorf("%s: refused = %v, want refused %t", tc.name, sh.Refused, tc.refused)
+		}
+		for _, path := range []string{"data.a", "data.b", "data.big", "mailbox.peer"} {
+			if k.HasStorage(ctx, path) {
+				t.Errorf("%s: earlier op's %s was kept", tc.name, path)
+			}
+		}
+		if got := k.GetStorage(ctx, "data.old").Value; got != "old" {
+			t.Errorf("%s: data.old = %q after a failed batch, want %q", tc.name, got, "old")
+		}
+		for _, event := range ctx.EventManager().Events() {
+			if event.Type == EventTypeStorage {
+				t.Errorf("%s: failed batch emitted a storage event", tc.name)
+			}
+		}
+	}
+}
@@ -12,6 +12,7 @@ const assigns = sargs.reduce((prior, assign) => {
     prior.push(
       `app_state.auth.params.tx_size_cost_per_byte="0"`,
       `app_state.staking.params.bond_denom="uagstake"`,
+      `app_state.crisis.constant_fee.denom="uagstake"`,
     );
   } else {
     prior.push(assign);